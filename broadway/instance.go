@@ -8,9 +8,50 @@ type Instance struct {
 	ID         string            `json:"id"`
 	Created    string            `json:"created"`
 	Vars       map[string]string `json:"vars"`
+	Cluster    string            `json:"cluster,omitempty"`
+	DeployState
 	Status
 }
 
+// DeployState holds what deployments record on an instance, apart from its
+// status. Updating an instance keeps it as a whole.
+type DeployState struct {
+	Namespace  string `json:"namespace,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	FailedTask string `json:"failed_task,omitempty"`
+	Deployed   string `json:"deployed,omitempty"`
+	// Revision numbers the last deployment of the instance
+	Revision int `json:"revision,omitempty"`
+	// PlaybookVersion is the uploaded version of the playbook the instance
+	// was last deployed from. It is zero for a playbook loaded from files.
+	PlaybookVersion int `json:"playbook_version,omitempty"`
+	// FailedRevision and RestoredRevision are set when a failed deployment
+	// was rolled back to the last good one
+	FailedRevision   int `json:"failed_revision,omitempty"`
+	RestoredRevision int `json:"restored_revision,omitempty"`
+	// FailingPods describes the pods that kept a rollout from becoming ready
+	// in the last deployment
+	FailingPods []PodStatus `json:"failing_pods,omitempty"`
+}
+
+// PodStatus reports the state of a pod that did not become ready
+type PodStatus struct {
+	Name       string            `json:"name"`
+	Phase      string            `json:"phase"`
+	Containers []ContainerStatus `json:"containers,omitempty"`
+}
+
+// ContainerStatus reports the state of one container of a pod
+type ContainerStatus struct {
+	Name         string `json:"name"`
+	Ready        bool   `json:"ready"`
+	RestartCount int32  `json:"restart_count"`
+	// State is the current state, e.g. "waiting: CrashLoopBackOff"
+	State string `json:"state"`
+	// LastTermination is why the container last terminated, if it has
+	LastTermination string `json:"last_termination,omitempty"`
+}
+
 type Status string
 
 const (
@@ -12,6 +12,7 @@ type InstanceRepository interface {
 	Save(instance Instance) error
 	FindByPath(path string) (Instance, error)
 	FindByID(playbookID, ID string) (Instance, error)
+	Delete(instance Instance) error
 }
 
 // InstanceRepo handles persistence logic
@@ -73,3 +74,8 @@ func (ir *InstanceRepo) FindByID(playbookID, ID string) (Instance, error) {
 	path := "/broadway/instances/" + playbookID + "/" + ID
 	return ir.FindByPath(path)
 }
+
+// Delete removes a stored instance
+func (ir *InstanceRepo) Delete(instance Instance) error {
+	return ir.store.Delete(instance.Path())
+}
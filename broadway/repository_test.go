@@ -17,6 +17,18 @@ func (ds *DummyStore) SetValue(path, value string) error {
 	return nil
 }
 
+func (ds *DummyStore) CreateValue(path, value string) error {
+	return nil
+}
+
+func (ds *DummyStore) SwapValue(path, old, value string) error {
+	return nil
+}
+
+func (ds *DummyStore) DeleteValue(path, old string) error {
+	return nil
+}
+
 func (ds *DummyStore) Values(path string) map[string]string {
 	return map[string]string{"foo": "foo"}
 }
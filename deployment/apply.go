@@ -0,0 +1,114 @@
+package deployment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/meta"
+	"k8s.io/kubernetes/pkg/api/v1"
+	coreclient "k8s.io/kubernetes/pkg/client/typed/generated/core/v1"
+	"k8s.io/kubernetes/pkg/runtime"
+)
+
+// Change describes what applying an object did to the cluster
+type Change string
+
+const (
+	// ChangeCreated means the object did not exist and was created
+	ChangeCreated Change = "created"
+	// ChangeUpdated means the live object was replaced with the manifest,
+	// updated in place or, for a pod, deleted and created again
+	ChangeUpdated Change = "updated"
+	// ChangeUnchanged means the live object already matched the manifest
+	ChangeUnchanged Change = "unchanged"
+)
+
+// appliedHashAnnotation records a hash of the manifest an object was last
+// applied from, so that redeploying an identical manifest is a no-op
+const appliedHashAnnotation = "broadway/applied-hash"
+
+// objectHash returns a hex encoded sha256 of the object's JSON encoding
+func objectHash(object runtime.Object) (string, error) {
+	encoded, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// applyObject creates object if it does not exist yet, and otherwise updates
+// the live object unless it was already applied from a manifest with the same
+// hash.
+func applyObject(client coreclient.CoreInterface, namespace string, object runtime.Object, hash string) (Change, error) {
+	m, err := meta.Accessor(object)
+	if err != nil {
+		return "", err
+	}
+	annotations := m.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[appliedHashAnnotation] = hash
+	m.SetAnnotations(annotations)
+
+	live, err := getObject(client, namespace, object)
+	if errors.IsNotFound(err) {
+		return ChangeCreated, createObject(client, namespace, object)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	lm, err := meta.Accessor(live)
+	if err != nil {
+		return "", err
+	}
+	if lm.GetAnnotations()[appliedHashAnnotation] == hash {
+		return ChangeUnchanged, nil
+	}
+	// Most of a pod's spec cannot be updated, so a changed pod is replaced
+	if pod, ok := object.(*v1.Pod); ok {
+		_, err := replacePod(client, namespace, pod, time.Now().Add(PodTimeout))
+		return ChangeUpdated, err
+	}
+	m.SetResourceVersion(lm.GetResourceVersion())
+	preserveLiveFields(object, live)
+	return ChangeUpdated, updateObject(client, namespace, object)
+}
+
+// preserveLiveFields copies fields assigned by the cluster from live onto
+// object when the manifest leaves them empty, so that an update does not try
+// to change them.
+func preserveLiveFields(object, live runtime.Object) {
+	switch o := object.(type) {
+	case *v1.Service:
+		l := live.(*v1.Service)
+		if o.Spec.ClusterIP == "" {
+			o.Spec.ClusterIP = l.Spec.ClusterIP
+		}
+		for i, port := range o.Spec.Ports {
+			if port.NodePort != 0 {
+				continue
+			}
+			for _, lport := range l.Spec.Ports {
+				if lport.Name == port.Name && lport.Port == port.Port {
+					o.Spec.Ports[i].NodePort = lport.NodePort
+				}
+			}
+		}
+	case *v1.ServiceAccount:
+		l := live.(*v1.ServiceAccount)
+		if len(o.Secrets) == 0 {
+			o.Secrets = l.Secrets
+		}
+	case *v1.PersistentVolumeClaim:
+		l := live.(*v1.PersistentVolumeClaim)
+		if o.Spec.VolumeName == "" {
+			o.Spec.VolumeName = l.Spec.VolumeName
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package deployment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/client/testing/core"
+	"k8s.io/kubernetes/pkg/client/typed/generated/core/v1/fake"
+	"k8s.io/kubernetes/pkg/runtime"
+
+	"github.com/namely/broadway/playbook"
+)
+
+var serviceManifest = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - name: http
+    port: 80
+  selector:
+    name: web
+`
+
+func TestApplyUpdatesLiveObject(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "services", func(action core.Action) (bool, runtime.Object, error) {
+		live := &v1.Service{}
+		live.Name = "web"
+		live.ResourceVersion = "7"
+		live.Spec.ClusterIP = "10.0.0.5"
+		live.Spec.Ports = []v1.ServicePort{{Name: "http", Port: 80, NodePort: 30080}}
+		return true, live, nil
+	})
+
+	step, err := NewDefaultStep(f, Owner{}, playbook.Task{Name: "step"}, "default", serviceManifest)
+	assert.Nil(t, err)
+	change, err := applyObject(f, "default", step.object, step.hash)
+	assert.Nil(t, err)
+	assert.Equal(t, ChangeUpdated, change)
+
+	actions := f.Actions()
+	if assert.Len(t, actions, 2) {
+		assert.Equal(t, "update", actions[1].GetVerb())
+		updated := actions[1].(core.UpdateAction).GetObject().(*v1.Service)
+		assert.Equal(t, "7", updated.ResourceVersion)
+		assert.Equal(t, "10.0.0.5", updated.Spec.ClusterIP)
+		assert.Equal(t, int32(30080), updated.Spec.Ports[0].NodePort)
+		assert.Equal(t, step.hash, updated.Annotations[appliedHashAnnotation])
+	}
+}
+
+func TestApplyUnchangedManifest(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	step, err := NewDefaultStep(f, Owner{}, playbook.Task{Name: "step"}, "default", serviceManifest)
+	assert.Nil(t, err)
+
+	f.AddReactor("get", "services", func(action core.Action) (bool, runtime.Object, error) {
+		live := &v1.Service{}
+		live.Name = "web"
+		live.Annotations = map[string]string{appliedHashAnnotation: step.hash}
+		return true, live, nil
+	})
+
+	change, err := applyObject(f, "default", step.object, step.hash)
+	assert.Nil(t, err)
+	assert.Equal(t, ChangeUnchanged, change)
+	assert.Len(t, f.Actions(), 1)
+}
+
+func TestApplyChangedManifest(t *testing.T) {
+	first, err := NewDefaultStep(nil, Owner{}, playbook.Task{Name: "step"}, "default", serviceManifest)
+	assert.Nil(t, err)
+	second, err := NewDefaultStep(nil, Owner{}, playbook.Task{Name: "step"}, "default", serviceManifest+"  type: NodePort\n")
+	assert.Nil(t, err)
+	assert.NotEqual(t, first.hash, second.hash)
+}
+
+func TestApplyReplacesChangedPod(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		live := &v1.Pod{}
+		live.Name = "migrate"
+		live.Annotations = map[string]string{appliedHashAnnotation: "old"}
+		return true, live, nil
+	})
+
+	step, err := NewDefaultStep(f, Owner{}, playbook.Task{Name: "step"}, "default", podTemplate)
+	assert.Nil(t, err)
+	change, err := applyObject(f, "default", step.object, step.hash)
+	assert.Nil(t, err)
+	assert.Equal(t, ChangeUpdated, change)
+
+	var verbs []string
+	for _, action := range f.Actions() {
+		verbs = append(verbs, action.GetVerb())
+	}
+	assert.Equal(t, []string{"get", "delete", "create"}, verbs)
+}
@@ -0,0 +1,89 @@
+package deployment
+
+import (
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/client/restclient"
+	coreclient "k8s.io/kubernetes/pkg/client/typed/generated/core/v1"
+)
+
+// The vendored CoreInterface has no PersistentVolumeClaims getter, so claims
+// are managed through the core REST client directly.
+
+// claimInterface has methods to work with PersistentVolumeClaim resources
+type claimInterface interface {
+	Create(*v1.PersistentVolumeClaim) (*v1.PersistentVolumeClaim, error)
+	Update(*v1.PersistentVolumeClaim) (*v1.PersistentVolumeClaim, error)
+	Get(name string) (*v1.PersistentVolumeClaim, error)
+	Delete(name string) error
+}
+
+// claimsGetter can be implemented by a CoreInterface that knows how to manage
+// PersistentVolumeClaims itself, e.g. a fake client in tests
+type claimsGetter interface {
+	PersistentVolumeClaims(namespace string) claimInterface
+}
+
+// claims implements claimInterface on top of a RESTClient
+type claims struct {
+	client *restclient.RESTClient
+	ns     string
+}
+
+// persistentVolumeClaims returns a claimInterface for the namespace, or an
+// UnsupportedKindError if client cannot reach the claims API
+func persistentVolumeClaims(client coreclient.CoreInterface, namespace string) (claimInterface, error) {
+	switch c := client.(type) {
+	case claimsGetter:
+		return c.PersistentVolumeClaims(namespace), nil
+	case *coreclient.CoreClient:
+		return &claims{client: c.RESTClient, ns: namespace}, nil
+	}
+	return nil, UnsupportedKindError{Kind: "PersistentVolumeClaim"}
+}
+
+// Create takes the representation of a claim and creates it
+func (c *claims) Create(claim *v1.PersistentVolumeClaim) (result *v1.PersistentVolumeClaim, err error) {
+	result = &v1.PersistentVolumeClaim{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("persistentvolumeclaims").
+		Body(claim).
+		Do().
+		Into(result)
+	return
+}
+
+// Update takes the representation of a claim and updates it
+func (c *claims) Update(claim *v1.PersistentVolumeClaim) (result *v1.PersistentVolumeClaim, err error) {
+	result = &v1.PersistentVolumeClaim{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("persistentvolumeclaims").
+		Name(claim.Name).
+		Body(claim).
+		Do().
+		Into(result)
+	return
+}
+
+// Get takes name of the claim, and returns the corresponding claim object
+func (c *claims) Get(name string) (result *v1.PersistentVolumeClaim, err error) {
+	result = &v1.PersistentVolumeClaim{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("persistentvolumeclaims").
+		Name(name).
+		Do().
+		Into(result)
+	return
+}
+
+// Delete takes name of the claim and deletes it
+func (c *claims) Delete(name string) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("persistentvolumeclaims").
+		Name(name).
+		Do().
+		Error()
+}
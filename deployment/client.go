@@ -0,0 +1,168 @@
+package deployment
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s.io/kubernetes/pkg/client/restclient"
+	coreclient "k8s.io/kubernetes/pkg/client/typed/generated/core/v1"
+)
+
+// DefaultHost is the API server used when no configuration names one
+const DefaultHost = "http://localhost:8080"
+
+// Names of the environment variables read by ClientConfigFromEnv
+const (
+	kubeconfigENV   string = "KUBECONFIG"
+	kubeContextENV  string = "KUBE_CONTEXT"
+	inClusterENV    string = "KUBE_IN_CLUSTER"
+	kubeHostENV     string = "KUBE_HOST"
+	kubeTokenENV    string = "KUBE_TOKEN"
+	kubeCAFileENV   string = "KUBE_CA_FILE"
+	kubeCertFileENV string = "KUBE_CERT_FILE"
+	kubeKeyFileENV  string = "KUBE_KEY_FILE"
+	kubeInsecureENV string = "KUBE_INSECURE"
+)
+
+// ClientConfig describes how to reach a Kubernetes API server. Credentials are
+// taken from the in-cluster service account when InCluster is set, otherwise
+// from the Kubeconfig file if one is given. Host, BearerToken and the TLS
+// fields override whatever those provide.
+type ClientConfig struct {
+	// Kubeconfig is the path to a kubectl config file
+	Kubeconfig string `yaml:"kubeconfig"`
+	// Context selects a kubeconfig context instead of its current-context
+	Context string `yaml:"context"`
+	// InCluster uses the service account Broadway runs as inside a cluster
+	InCluster bool `yaml:"in_cluster"`
+
+	// Host is the URL of the API server
+	Host        string `yaml:"host"`
+	BearerToken string `yaml:"token"`
+	CAFile      string `yaml:"ca_file"`
+	CertFile    string `yaml:"cert_file"`
+	KeyFile     string `yaml:"key_file"`
+	Insecure    bool   `yaml:"insecure"`
+}
+
+// ClientConfigFromEnv builds a ClientConfig from KUBECONFIG, KUBE_CONTEXT,
+// KUBE_IN_CLUSTER, KUBE_HOST, KUBE_TOKEN, KUBE_CA_FILE, KUBE_CERT_FILE,
+// KUBE_KEY_FILE and KUBE_INSECURE
+func ClientConfigFromEnv() ClientConfig {
+	return ClientConfig{
+		Kubeconfig:  os.Getenv(kubeconfigENV),
+		Context:     os.Getenv(kubeContextENV),
+		InCluster:   os.Getenv(inClusterENV) == "true",
+		Host:        os.Getenv(kubeHostENV),
+		BearerToken: os.Getenv(kubeTokenENV),
+		CAFile:      os.Getenv(kubeCAFileENV),
+		CertFile:    os.Getenv(kubeCertFileENV),
+		KeyFile:     os.Getenv(kubeKeyFileENV),
+		Insecure:    os.Getenv(kubeInsecureENV) == "true",
+	}
+}
+
+// RESTConfig resolves the ClientConfig into a restclient.Config
+func (c ClientConfig) RESTConfig() (*restclient.Config, error) {
+	cfg := &restclient.Config{}
+	var err error
+	switch {
+	case c.InCluster:
+		cfg, err = restclient.InClusterConfig()
+	case c.Kubeconfig != "":
+		cfg, err = loadKubeconfig(c.Kubeconfig, c.Context)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Host != "" {
+		cfg.Host = c.Host
+	}
+	if c.BearerToken != "" {
+		cfg.BearerToken = c.BearerToken
+	}
+	if c.CAFile != "" {
+		cfg.CAFile = c.CAFile
+		cfg.CAData = nil
+	}
+	if c.CertFile != "" {
+		cfg.CertFile = c.CertFile
+		cfg.CertData = nil
+	}
+	if c.KeyFile != "" {
+		cfg.KeyFile = c.KeyFile
+		cfg.KeyData = nil
+	}
+	if c.Insecure {
+		cfg.Insecure = true
+	}
+	if cfg.Host == "" {
+		cfg.Host = DefaultHost
+		cfg.Insecure = true
+	}
+	wrap := cfg.WrapTransport
+	cfg.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return answerTimeout{rt}
+	}
+	return cfg, nil
+}
+
+// APITimeout bounds how long a request to a Kubernetes API server waits for
+// the server to answer. Watches stream their events for longer once
+// answered.
+var APITimeout = 30 * time.Second
+
+// APITimeoutError is returned when an API server does not answer a request
+// within APITimeout
+type APITimeoutError struct {
+	Method string
+	URL    string
+	After  time.Duration
+}
+
+func (e APITimeoutError) Error() string {
+	return fmt.Sprintf("%s %s got no answer within %s", e.Method, e.URL, e.After)
+}
+
+// Timeout marks APITimeoutError as a net.Error timeout
+func (e APITimeoutError) Timeout() bool { return true }
+
+// Temporary marks APITimeoutError as a temporary net.Error
+func (e APITimeoutError) Temporary() bool { return true }
+
+// answerTimeout cancels requests the API server does not answer within
+// APITimeout
+type answerTimeout struct {
+	rt http.RoundTripper
+}
+
+func (t answerTimeout) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Cancel != nil {
+		return t.rt.RoundTrip(req)
+	}
+	timeout := APITimeout
+	cancel := make(chan struct{})
+	bounded := *req
+	bounded.Cancel = cancel
+	timer := time.AfterFunc(timeout, func() { close(cancel) })
+	resp, err := t.rt.RoundTrip(&bounded)
+	if !timer.Stop() && err != nil {
+		return nil, APITimeoutError{Method: req.Method, URL: req.URL.String(), After: timeout}
+	}
+	return resp, err
+}
+
+// NewClient creates a Kubernetes client from a ClientConfig
+func NewClient(c ClientConfig) (coreclient.CoreInterface, error) {
+	cfg, err := c.RESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return coreclient.NewForConfig(cfg)
+}
@@ -0,0 +1,78 @@
+package deployment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRESTConfigDefaultsToLocalhost(t *testing.T) {
+	cfg, err := ClientConfig{}.RESTConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultHost, cfg.Host)
+	assert.True(t, cfg.Insecure)
+}
+
+func TestRESTConfigOverridesKubeconfig(t *testing.T) {
+	path, cleanup := writeTestKubeconfig(t)
+	defer cleanup()
+
+	cfg, err := ClientConfig{
+		Kubeconfig:  path,
+		Host:        "https://10.0.0.1",
+		BearerToken: "override",
+		CAFile:      "/etc/ca.pem",
+	}.RESTConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "https://10.0.0.1", cfg.Host)
+	assert.Equal(t, "override", cfg.BearerToken)
+	assert.Equal(t, "/etc/ca.pem", cfg.CAFile)
+	assert.False(t, cfg.Insecure)
+}
+
+func TestRESTConfigInClusterWithoutServiceAccount(t *testing.T) {
+	_, err := ClientConfig{InCluster: true}.RESTConfig()
+	assert.NotNil(t, err)
+}
+
+func TestClientConfigFromEnv(t *testing.T) {
+	os.Setenv(kubeHostENV, "https://k8s.example.com")
+	os.Setenv(kubeInsecureENV, "true")
+	defer os.Unsetenv(kubeHostENV)
+	defer os.Unsetenv(kubeInsecureENV)
+
+	c := ClientConfigFromEnv()
+	assert.Equal(t, "https://k8s.example.com", c.Host)
+	assert.True(t, c.Insecure)
+	assert.False(t, c.InCluster)
+}
+
+func TestNewClient(t *testing.T) {
+	client, err := NewClient(ClientConfig{Host: "http://127.0.0.1:8080"})
+	assert.Nil(t, err)
+	assert.NotNil(t, client)
+}
+
+func TestClientTimesOutUnansweredRequests(t *testing.T) {
+	defer func(timeout time.Duration) { APITimeout = timeout }(APITimeout)
+	APITimeout = 20 * time.Millisecond
+	answer := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-answer
+	}))
+	defer server.Close()
+	defer close(answer)
+
+	client, err := NewClient(ClientConfig{Host: server.URL})
+	assert.Nil(t, err)
+	_, err = client.Services("default").Get("web")
+	assert.True(t, IsRetryable(err), "%v", err)
+	if urlErr, ok := err.(*url.Error); assert.True(t, ok, "%#v", err) {
+		assert.IsType(t, APITimeoutError{}, urlErr.Err)
+	}
+}
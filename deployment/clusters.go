@@ -0,0 +1,131 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+	coreclient "k8s.io/kubernetes/pkg/client/typed/generated/core/v1"
+)
+
+// DefaultCluster names the only cluster of a Clusters made by SingleCluster
+const DefaultCluster = "default"
+
+// UnknownClusterError is returned when an instance or playbook names a
+// cluster that is not configured
+type UnknownClusterError struct {
+	Name string
+}
+
+func (e UnknownClusterError) Error() string {
+	return fmt.Sprintf("Cluster %s is not configured", e.Name)
+}
+
+// Clusters holds a Kubernetes client for each named cluster Broadway can
+// deploy to
+type Clusters struct {
+	// Default is the cluster used when neither an instance nor its playbook
+	// name one
+	Default string
+
+	clients map[string]coreclient.CoreInterface
+}
+
+// clustersFile is the format of a cluster targets file, e.g.
+//
+//	default: staging
+//	clusters:
+//	  staging:
+//	    kubeconfig: /etc/broadway/kubeconfig
+//	    context: staging
+//	  qa:
+//	    host: https://qa.example.com
+//	    token: s3cret
+type clustersFile struct {
+	Default  string                  `yaml:"default"`
+	Clusters map[string]ClientConfig `yaml:"clusters"`
+}
+
+// SingleCluster returns Clusters with client as its only, default cluster
+func SingleCluster(client coreclient.CoreInterface) *Clusters {
+	return &Clusters{
+		Default: DefaultCluster,
+		clients: map[string]coreclient.CoreInterface{DefaultCluster: client},
+	}
+}
+
+// NewClusters creates a client for every named ClientConfig. def names the
+// default cluster, and may be left empty when there is a single cluster.
+func NewClusters(configs map[string]ClientConfig, def string) (*Clusters, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("At least one cluster must be configured")
+	}
+	if def == "" && len(configs) == 1 {
+		for name := range configs {
+			def = name
+		}
+	}
+	if _, ok := configs[def]; !ok {
+		return nil, fmt.Errorf("Default cluster %q is not configured", def)
+	}
+
+	c := &Clusters{Default: def, clients: map[string]coreclient.CoreInterface{}}
+	for name, config := range configs {
+		client, err := NewClient(config)
+		if err != nil {
+			return nil, fmt.Errorf("Cluster %s: %s", name, err)
+		}
+		c.clients[name] = client
+	}
+	return c, nil
+}
+
+// LoadClusters reads cluster targets from a YAML file
+func LoadClusters(path string) (*Clusters, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f clustersFile
+	if err := yaml.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("Failed to parse clusters file %s: %s", path, err)
+	}
+	return NewClusters(f.Clusters, f.Default)
+}
+
+// Names returns the configured cluster names in order
+func (c *Clusters) Names() []string {
+	names := make([]string, 0, len(c.clients))
+	for name := range c.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve picks the cluster for an instance: its own cluster if set, else
+// its playbook's, else the default
+func (c *Clusters) Resolve(instanceCluster, playbookCluster string) string {
+	if instanceCluster != "" {
+		return instanceCluster
+	}
+	if playbookCluster != "" {
+		return playbookCluster
+	}
+	return c.Default
+}
+
+// Client returns the client for the named cluster, or for the default
+// cluster if name is empty
+func (c *Clusters) Client(name string) (coreclient.CoreInterface, error) {
+	if name == "" {
+		name = c.Default
+	}
+	client, ok := c.clients[name]
+	if !ok {
+		return nil, UnknownClusterError{Name: name}
+	}
+	return client, nil
+}
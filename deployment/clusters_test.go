@@ -0,0 +1,59 @@
+package deployment
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadClusters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broadway-clusters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "clusters.yml")
+	err = ioutil.WriteFile(path, []byte(`default: staging
+clusters:
+  staging:
+    host: http://staging.example.com
+  qa:
+    host: https://qa.example.com
+    token: s3cret
+`), 0600)
+	assert.Nil(t, err)
+
+	clusters, err := LoadClusters(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "staging", clusters.Default)
+	assert.Equal(t, []string{"qa", "staging"}, clusters.Names())
+
+	_, err = clusters.Client("qa")
+	assert.Nil(t, err)
+	_, err = clusters.Client("")
+	assert.Nil(t, err)
+	_, err = clusters.Client("prod")
+	assert.Equal(t, UnknownClusterError{Name: "prod"}, err)
+}
+
+func TestNewClustersDefault(t *testing.T) {
+	clusters, err := NewClusters(map[string]ClientConfig{"only": {}}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "only", clusters.Default)
+
+	_, err = NewClusters(map[string]ClientConfig{"a": {}, "b": {}}, "")
+	assert.NotNil(t, err)
+
+	_, err = NewClusters(map[string]ClientConfig{}, "")
+	assert.NotNil(t, err)
+}
+
+func TestClustersResolve(t *testing.T) {
+	clusters := SingleCluster(nil)
+	assert.Equal(t, "qa", clusters.Resolve("qa", "staging"))
+	assert.Equal(t, "staging", clusters.Resolve("", "staging"))
+	assert.Equal(t, DefaultCluster, clusters.Resolve("", ""))
+}
@@ -1,11 +1,13 @@
 package deployment
 
 import (
+	"fmt"
+	"time"
+
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/api/meta"
 	"k8s.io/kubernetes/pkg/api/unversioned"
 	"k8s.io/kubernetes/pkg/api/v1"
-	"k8s.io/kubernetes/pkg/client/restclient"
 	coreclient "k8s.io/kubernetes/pkg/client/typed/generated/core/v1"
 	"k8s.io/kubernetes/pkg/runtime"
 	"k8s.io/kubernetes/pkg/runtime/serializer"
@@ -13,8 +15,10 @@ import (
 	// Install API
 	_ "k8s.io/kubernetes/pkg/api/install"
 
+	"github.com/namely/broadway/instance"
 	"github.com/namely/broadway/manifest"
 	"github.com/namely/broadway/playbook"
+	"github.com/namely/broadway/store"
 )
 
 var groupVersionKind = unversioned.GroupVersionKind{
@@ -23,7 +27,6 @@ var groupVersionKind = unversioned.GroupVersionKind{
 	Kind:    meta.AnyKind,
 }
 
-var client coreclient.CoreInterface
 var deserializer runtime.Decoder
 
 func init() {
@@ -32,43 +35,317 @@ func init() {
 	v1.AddToScheme(scheme)
 	factory := serializer.NewCodecFactory(scheme)
 	deserializer = factory.UniversalDeserializer()
-
-	kcfg := &restclient.Config{
-		Host:     "http://localhost:8080",
-		Insecure: true,
-	}
-	var err error
-	client, err = coreclient.NewForConfig(kcfg)
-	if err != nil {
-		panic(err)
-	}
 }
 
 // Deployment represents a deployment of an instance
 type Deployment struct {
+	// Client is the Kubernetes client manifests are applied with
+	Client    coreclient.CoreInterface
 	Playbook  playbook.Playbook
 	Variables map[string]string
 	Manifests map[string]*manifest.Manifest
+	// Namespace is the Kubernetes namespace manifests are applied into. An
+	// empty Namespace means the "default" namespace.
+	Namespace string
+	// FirstDeploy is set when the instance has never been deployed
+	// successfully, for task when conditions
+	FirstDeploy bool
+
+	// Store, when set, receives a Revision recording each Deploy and
+	// Rollback of the instance InstanceID
+	Store      store.Store
+	InstanceID string
+	// Revision numbers the revision recorded
+	Revision int
+	// User is who asked for the deployment
+	User string
+	// Created is when the instance was created, for manifests
+	Created string
+	// PlaybookVersion numbers the uploaded version of Playbook deployed. It
+	// is zero for a playbook loaded from files.
+	PlaybookVersion int
+	// Stop, when closed, ends waiting to retry a task
+	Stop <-chan struct{}
+}
+
+// DeployInstance deploys the playbook with the instance's vars into a
+// namespace of its own, which is created if it does not exist yet. The
+// namespace name is saved on the instance attributes.
+func (d *Deployment) DeployInstance(i instance.Instance) (*Result, error) {
+	if err := d.prepare(i); err != nil {
+		return nil, err
+	}
+	return d.Deploy()
+}
+
+// RollbackInstance applies the manifests of an earlier revision of the
+// instance again, into its namespace
+func (d *Deployment) RollbackInstance(i instance.Instance, target *Revision) (*Result, error) {
+	if err := d.prepare(i); err != nil {
+		return nil, err
+	}
+	return d.Rollback(target)
+}
+
+// prepare ensures the instance has a namespace and takes the instance's
+// namespace, vars, with the playbook's defaults, and revision
+func (d *Deployment) prepare(i instance.Instance) error {
+	attrs := i.Attributes()
+	// An instance stays in the namespace it was first deployed into
+	namespace := attrs.Namespace
+	if namespace == "" {
+		namespace = NamespaceFor(attrs.PlaybookID, attrs.ID)
+	}
+	if err := ensureNamespace(d.Client, namespace); err != nil {
+		return err
+	}
+	if err := i.Update(func(attrs *instance.Attributes) { attrs.Namespace = namespace }); err != nil {
+		return err
+	}
+
+	d.Namespace = namespace
+	d.Variables = d.Playbook.WithDefaults(attrs.Vars)
+	d.FirstDeploy = attrs.Deployed == ""
+	d.InstanceID = attrs.ID
+	d.Revision = attrs.Revision
+	d.Created = attrs.Created
+	return nil
+}
+
+// Deploy executes the deployment and reports what each step changed. Tasks
+// whose when condition is false are skipped.
+func (d *Deployment) Deploy() (*Result, error) {
+	rev := d.newRevision()
+	result, err := d.deployTasks(rev)
+	d.record(rev, err)
+	return result, err
 }
 
-// Deploy executes the deployment
-func (d *Deployment) Deploy() error {
-	for _, task := range d.Playbook.Tasks {
-		if len(task.Manifests) > 0 {
-			for _, name := range task.Manifests {
-				m := d.Manifests[name]
-				rendered := m.Execute(d.Variables)
-				step, err := NewDefaultStep(task, rendered)
-				if err != nil {
-					return err
-				}
-				err = step.Deploy()
-				if err != nil {
-					return err
-				}
+// taskOutcome is how one task of a deployment went
+type taskOutcome struct {
+	index    int
+	result   TaskResult
+	rendered []string
+	skipped  bool
+	err      error
+}
+
+// deployTasks runs the playbook's tasks once the tasks they depend on have
+// finished, up to the playbook's parallelism at a time. After a task fails no
+// more tasks are started, and the tasks already running are waited for.
+// Results are reported in playbook order.
+func (d *Deployment) deployTasks(rev *Revision) (*Result, error) {
+	result := &Result{}
+	deps, err := d.Playbook.Dependencies()
+	if err != nil {
+		return result, err
+	}
+	state := playbook.State{Vars: d.Variables, FirstDeploy: d.FirstDeploy}
+	tasks := d.Playbook.Tasks
+	outcomes := make([]*taskOutcome, len(tasks))
+	started := make([]bool, len(tasks))
+	finished := make(chan taskOutcome)
+	running := 0
+	var failed error
+
+	ready := func(n int) bool {
+		for _, dep := range deps[n] {
+			if outcomes[dep] == nil {
+				return false
 			}
 		}
+		return true
+	}
+	for {
+		for n := range tasks {
+			if failed != nil || running >= d.Playbook.Parallelism() {
+				break
+			}
+			if started[n] || !ready(n) {
+				continue
+			}
+			started[n] = true
+			running++
+			go func(n int) {
+				finished <- d.runTask(n, state)
+			}(n)
+		}
+		if running == 0 {
+			break
+		}
+		outcome := <-finished
+		running--
+		outcomes[outcome.index] = &outcome
+		if outcome.err != nil && failed == nil {
+			failed = TaskError{Task: tasks[outcome.index].Name, Err: outcome.err}
+		}
 	}
 
-	return nil
+	for n, outcome := range outcomes {
+		if outcome == nil {
+			continue
+		}
+		name := tasks[n].Name
+		if outcome.skipped {
+			result.Tasks = append(result.Tasks, TaskResult{Name: name, Skipped: true})
+			rev.Tasks = append(rev.Tasks, RevisionTask{Name: name, Outcome: OutcomeSkipped})
+			continue
+		}
+		// A task whose when condition failed to evaluate has no result
+		if outcome.result.Name != "" {
+			result.Tasks = append(result.Tasks, outcome.result)
+		}
+		taskResult := outcome.result
+		taskResult.Name = name
+		rev.addTask(taskResult, outcome.rendered, outcome.err)
+	}
+	return result, failed
+}
+
+// runTask deploys the task at index n, unless its when condition is false.
+// A task that fails with a retryable error is deployed again, up to its
+// retries, after a delay that doubles with each attempt. Closing d.Stop ends
+// the wait, and the task fails with its last error.
+func (d *Deployment) runTask(n int, state playbook.State) taskOutcome {
+	task := d.Playbook.Tasks[n]
+	run, err := task.ShouldRun(state)
+	if err != nil {
+		return taskOutcome{index: n, err: err}
+	}
+	if !run {
+		return taskOutcome{index: n, skipped: true}
+	}
+	var attempts []Attempt
+	for attempt := 1; ; attempt++ {
+		result, rendered, err := d.deployTask(task)
+		if task.Retries > 0 {
+			attempts = append(attempts, newAttempt(attempt, err))
+			result.Attempts = attempts
+		}
+		outcome := taskOutcome{index: n, result: result, rendered: rendered, err: err}
+		if err == nil || attempt > task.Retries || !IsRetryable(err) {
+			return outcome
+		}
+		select {
+		case <-time.After(retryDelay(task, attempt)):
+		case <-d.Stop:
+			return outcome
+		}
+	}
+}
+
+// deployTask applies the objects of the task's manifests in order, then runs
+// its pod manifest. It returns the documents it applied, as rendered. The
+// attempt ends with a TaskTimeoutError once the task's timeout, or
+// TaskTimeout, passed; waits for pods end at that deadline too.
+func (d *Deployment) deployTask(task playbook.Task) (TaskResult, []string, error) {
+	result := TaskResult{Name: task.Name}
+	timeout := timeoutFor(task, TaskTimeout)
+	deadline := time.Now().Add(timeout)
+	expired := func() error {
+		if time.Now().After(deadline) {
+			return TaskTimeoutError{Task: task.Name, Timeout: timeout}
+		}
+		return nil
+	}
+	var applied []string
+	for _, name := range task.Manifests {
+		documents, err := d.renderDocuments(name)
+		if err != nil {
+			return result, applied, err
+		}
+		for _, document := range documents {
+			if err := expired(); err != nil {
+				return result, applied, err
+			}
+			step, err := NewDefaultStep(d.Client, d.owner(), task, d.namespace(), document)
+			if err != nil {
+				return result, applied, ManifestError{Manifest: name, Err: err}
+			}
+			step.deadline = deadline
+			objectResult, err := step.Deploy()
+			if err != nil {
+				return result, applied, objectError(name, err)
+			}
+			result.Objects = append(result.Objects, objectResult)
+			applied = append(applied, document)
+		}
+	}
+
+	if task.PodManifest == "" {
+		return result, applied, nil
+	}
+	if err := expired(); err != nil {
+		return result, applied, err
+	}
+	pod, err := d.renderPod(task.PodManifest)
+	if err != nil {
+		return result, applied, err
+	}
+	step, err := NewPodStep(d.Client, d.owner(), task, d.namespace(), pod)
+	if err != nil {
+		return result, applied, err
+	}
+	step.deadline = deadline
+	podResult, err := step.Run()
+	result.Pod = &podResult
+	return result, applied, err
+}
+
+// render executes the named manifest with the deployment's vars and
+// builtins, strictly if the playbook sets strict_vars
+func (d *Deployment) render(name string) (string, error) {
+	m, ok := d.Manifests[name]
+	if !ok {
+		return "", fmt.Errorf("Manifest %s not found", name)
+	}
+	if d.Playbook.StrictVars {
+		return m.ExecuteStrict(d.Variables, d.builtins())
+	}
+	return m.Execute(d.Variables, d.builtins())
+}
+
+// renderDocuments renders the named manifest and splits it into the
+// documents of the objects it holds, in order
+func (d *Deployment) renderDocuments(name string) ([]string, error) {
+	rendered, err := d.render(name)
+	if err != nil {
+		return nil, err
+	}
+	documents := manifest.Documents(rendered)
+	if len(documents) == 0 {
+		return nil, fmt.Errorf("Manifest %s has no objects", name)
+	}
+	return documents, nil
+}
+
+// renderPod renders the named pod manifest, which must hold a single object
+func (d *Deployment) renderPod(name string) (string, error) {
+	documents, err := d.renderDocuments(name)
+	if err != nil {
+		return "", err
+	}
+	if len(documents) > 1 {
+		return "", fmt.Errorf("Pod manifest %s holds %d objects, not one Pod", name, len(documents))
+	}
+	return documents[0], nil
+}
+
+// builtins returns the values manifests read under .broadway
+func (d *Deployment) builtins() manifest.Builtins {
+	return manifest.Builtins{
+		InstanceID: d.InstanceID,
+		PlaybookID: d.Playbook.ID,
+		Namespace:  d.namespace(),
+		Created:    d.Created,
+		Revision:   d.Revision,
+		User:       d.User,
+	}
+}
+
+func (d *Deployment) namespace() string {
+	if d.Namespace == "" {
+		return api.NamespaceDefault
+	}
+	return d.Namespace
 }
@@ -1,26 +1,33 @@
 package deployment
 
 import (
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/api/v1"
 	"k8s.io/kubernetes/pkg/client/testing/core"
+	coreclient "k8s.io/kubernetes/pkg/client/typed/generated/core/v1"
 	"k8s.io/kubernetes/pkg/client/typed/generated/core/v1/fake"
+	"k8s.io/kubernetes/pkg/runtime"
 
+	"github.com/namely/broadway/broadway"
+	"github.com/namely/broadway/instance"
 	"github.com/namely/broadway/manifest"
 	"github.com/namely/broadway/playbook"
+	"github.com/namely/broadway/store"
 )
 
-func init() {
-	client = &fake.FakeCore{&core.Fake{}}
-}
-
 func TestDeploy(t *testing.T) {
 	p := playbook.Playbook{
 		ID:   "test",
 		Name: "Test deployment",
 		Meta: playbook.Meta{},
-		Vars: []string{"test"},
+		Vars: []playbook.Var{{Name: "test"}},
 		Tasks: []playbook.Task{
 			{
 				Name: "First step",
@@ -41,16 +48,257 @@ func TestDeploy(t *testing.T) {
 		"test": m,
 	}
 
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.PrependReactor("get", "*", notFoundReaction)
+
 	d := &Deployment{
+		Client:    f,
 		Playbook:  p,
 		Variables: v,
 		Manifests: ms,
 	}
 
-	err := d.Deploy()
+	result, err := d.Deploy()
+	assert.Nil(t, err)
+	actions := f.Actions()
+	if assert.Equal(t, 2, len(actions)) {
+		assert.Equal(t, "get", actions[0].GetVerb())
+		assert.Equal(t, "create", actions[1].GetVerb())
+	}
+	assert.Equal(t, []TaskResult{
+		{
+			Name: "First step",
+			Objects: []ObjectResult{
+				{Kind: "ReplicationController", Name: "test", Change: ChangeCreated},
+			},
+		},
+	}, result.Tasks)
+}
+
+func TestDeployInstance(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+
+	m, _ := manifest.New("test", mtemplate)
+	mem := store.NewMemory()
+	i := instance.New(mem, &instance.Attributes{
+		PlaybookID: "test",
+		ID:         "pr-1",
+		Vars:       map[string]string{"test": "ok"},
+	})
+	assert.Nil(t, i.Save())
+
+	d := &Deployment{
+		Client: f,
+		Playbook: playbook.Playbook{
+			ID:    "test",
+			Tasks: []playbook.Task{{Name: "First step", Manifests: []string{"test"}}},
+		},
+		Manifests: map[string]*manifest.Manifest{"test": m},
+	}
+
+	_, err := d.DeployInstance(i)
+	assert.Nil(t, err)
+	namespace := NamespaceFor("test", "pr-1")
+	assert.Equal(t, namespace, i.Attributes().Namespace)
+	assert.Contains(t, mem.Value("/broadway/instances/test/pr-1"), `"namespace":"`+namespace+`"`)
+	assert.True(t, d.FirstDeploy)
+
+	actions := f.Actions()
+	if assert.Len(t, actions, 4) {
+		assert.Equal(t, "namespaces", actions[1].GetResource())
+		assert.Equal(t, "create", actions[1].GetVerb())
+		assert.Equal(t, namespace, actions[3].GetNamespace())
+	}
+}
+
+func TestDeployInstanceKeepsItsNamespace(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+	m, _ := manifest.New("test", mtemplate)
+	i := instance.New(store.NewMemory(), &instance.Attributes{PlaybookID: "test", ID: "pr-1", DeployState: broadway.DeployState{Namespace: "broadway-test-pr-1"}})
+	assert.Nil(t, i.Save())
+	d := &Deployment{
+		Client: f,
+		Playbook: playbook.Playbook{
+			ID:    "test",
+			Tasks: []playbook.Task{{Name: "First step", Manifests: []string{"test"}}},
+		},
+		Manifests: map[string]*manifest.Manifest{"test": m},
+	}
+
+	_, err := d.DeployInstance(i)
+	assert.Nil(t, err)
+	assert.Equal(t, "broadway-test-pr-1", d.Namespace)
+	assert.Equal(t, "broadway-test-pr-1", i.Attributes().Namespace)
+}
+
+func TestDeployInstanceBuiltins(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+
+	m, _ := manifest.New("test", `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{.broadway.playbook_id}}-{{.broadway.instance_id}}
+data:
+  namespace: {{.broadway.namespace}}
+  created: {{.broadway.created}}
+  revision: "{{.broadway.revision}}"
+  user: {{.broadway.user}}
+`)
+	i := instance.New(store.NewMemory(), &instance.Attributes{
+		PlaybookID: "test",
+		ID:         "pr-1",
+		Created:    "2016-05-10T14:02:11Z",
+		Revision:   4,
+		Vars:       map[string]string{"broadway": "ignored"},
+	})
+	assert.Nil(t, i.Save())
+	d := &Deployment{
+		Client: f,
+		Playbook: playbook.Playbook{
+			ID:    "test",
+			Tasks: []playbook.Task{{Name: "Config", Manifests: []string{"test"}}},
+		},
+		Manifests: map[string]*manifest.Manifest{"test": m},
+		User:      "alice",
+	}
+
+	_, err := d.DeployInstance(i)
 	assert.Nil(t, err)
-	f := client.(*fake.FakeCore).Fake
-	assert.Equal(t, 1, len(f.Actions()))
+	actions := f.Actions()
+	if assert.Len(t, actions, 4) {
+		created := actions[3].(core.CreateAction).GetObject().(*v1.ConfigMap)
+		assert.Equal(t, "test-pr-1", created.Name)
+		assert.Equal(t, map[string]string{
+			"namespace": NamespaceFor("test", "pr-1"),
+			"created":   "2016-05-10T14:02:11Z",
+			"revision":  "4",
+			"user":      "alice",
+		}, created.Data)
+	}
+}
+
+func TestDeployMultiDocumentManifest(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+
+	bundle, _ := manifest.New("redis", mtemplate+"---\n"+`apiVersion: v1
+kind: Service
+metadata:
+  name: redis
+spec:
+  ports:
+  - port: 6379
+`)
+	exported, _ := manifest.New("config", `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "config"}}`)
+	d := &Deployment{
+		Client: f,
+		Playbook: playbook.Playbook{
+			ID:    "test",
+			Tasks: []playbook.Task{{Name: "Redis", Manifests: []string{"redis", "config"}}},
+		},
+		Manifests: map[string]*manifest.Manifest{"redis": bundle, "config": exported},
+		Store:     store.NewMemory(),
+		Revision:  1,
+	}
+
+	result, err := d.Deploy()
+	assert.Nil(t, err)
+	assert.Equal(t, []ObjectResult{
+		{Kind: "ReplicationController", Name: "test", Change: ChangeCreated},
+		{Kind: "Service", Name: "redis", Change: ChangeCreated},
+		{Kind: "ConfigMap", Name: "config", Change: ChangeCreated},
+	}, result.Tasks[0].Objects)
+
+	rev, err := GetRevision(d.Store, "test", "", 1)
+	assert.Nil(t, err)
+	assert.Len(t, rev.Tasks[0].Manifests, 3)
+}
+
+func TestDeployPodManifestWithSeveralObjects(t *testing.T) {
+	m, _ := manifest.New("migrate", podTemplate+"---\n"+podTemplate)
+	d := &Deployment{
+		Client: &fake.FakeCore{Fake: &core.Fake{}},
+		Playbook: playbook.Playbook{
+			ID:    "test",
+			Tasks: []playbook.Task{{Name: "Migrate", PodManifest: "migrate"}},
+		},
+		Manifests: map[string]*manifest.Manifest{"migrate": m},
+	}
+
+	_, err := d.Deploy()
+	assert.EqualError(t, err, "Task Migrate failed: Pod manifest migrate holds 2 objects, not one Pod")
+}
+
+func TestDeploySkipsTasksByCondition(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+
+	m, _ := manifest.New("test", mtemplate)
+	d := &Deployment{
+		Client: f,
+		Playbook: playbook.Playbook{
+			ID: "test",
+			Tasks: []playbook.Task{
+				{Name: "Setup", Manifests: []string{"test"}, When: "new_deployment"},
+				{Name: "Production only", Manifests: []string{"test"}, When: "env == production"},
+			},
+		},
+		Variables: map[string]string{"env": "staging"},
+		Manifests: map[string]*manifest.Manifest{"test": m},
+	}
+
+	result, err := d.Deploy()
+	assert.Nil(t, err)
+	assert.Equal(t, []TaskResult{
+		{Name: "Setup", Skipped: true},
+		{Name: "Production only", Skipped: true},
+	}, result.Tasks)
+	assert.Empty(t, f.Actions())
+
+	d.FirstDeploy = true
+	result, err = d.Deploy()
+	assert.Nil(t, err)
+	assert.False(t, result.Tasks[0].Skipped)
+	assert.True(t, result.Tasks[1].Skipped)
+}
+
+func TestDeployInvalidCondition(t *testing.T) {
+	d := &Deployment{
+		Client: &fake.FakeCore{Fake: &core.Fake{}},
+		Playbook: playbook.Playbook{
+			ID:    "test",
+			Tasks: []playbook.Task{{Name: "Broken", Manifests: []string{"test"}, When: "env =="}},
+		},
+	}
+
+	_, err := d.Deploy()
+	assert.EqualError(t, err, "Task Broken failed: Expected a value at position 6, found end of expression")
+}
+
+func TestDeployStrictVars(t *testing.T) {
+	m, _ := manifest.New("web-rc", "apiVersion: v1\nkind: ReplicationController\nmetadata:\n  name: {{.name}}\n")
+	d := &Deployment{
+		Client: &fake.FakeCore{Fake: &core.Fake{}},
+		Playbook: playbook.Playbook{
+			ID:         "test",
+			StrictVars: true,
+			Tasks:      []playbook.Task{{Name: "Deploy Web", Manifests: []string{"web-rc"}}},
+		},
+		Variables: map[string]string{},
+		Manifests: map[string]*manifest.Manifest{"web-rc": m},
+	}
+
+	_, err := d.Deploy()
+	assert.EqualError(t, err, "Task Deploy Web failed: manifest web-rc: missing var name at line 4")
+	assert.Equal(t, manifest.MissingVarError{Manifest: "web-rc", Var: "name", Line: 4}, err.(TaskError).Err)
+	assert.Empty(t, d.Client.(*fake.FakeCore).Actions())
+}
+
+func notFoundReaction(action core.Action) (bool, runtime.Object, error) {
+	return true, nil, errors.NewNotFound(unversioned.GroupResource{Resource: action.GetResource()}, "")
 }
 
 var mtemplate = `apiVersion: v1
@@ -70,3 +318,137 @@ spec:
       - name: redis
         image: kubernetes/redis:v1
 `
+
+// concurrentCreates counts the services a client creates at once
+type concurrentCreates struct {
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (c *concurrentCreates) enter() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current++
+	if c.current > c.max {
+		c.max = c.current
+	}
+}
+
+func (c *concurrentCreates) leave() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current--
+}
+
+// slowServices is a fake client whose service creates take a while, outside
+// the lock the fake holds while calling reactors
+type slowServices struct {
+	*fake.FakeCore
+	creates *concurrentCreates
+}
+
+func (c slowServices) Services(namespace string) coreclient.ServiceInterface {
+	return slowServiceClient{c.FakeCore.Services(namespace), c.creates}
+}
+
+type slowServiceClient struct {
+	coreclient.ServiceInterface
+	creates *concurrentCreates
+}
+
+func (c slowServiceClient) Create(service *v1.Service) (*v1.Service, error) {
+	c.creates.enter()
+	defer c.creates.leave()
+	time.Sleep(50 * time.Millisecond)
+	return c.ServiceInterface.Create(service)
+}
+
+func newGraphDeployment(f coreclient.CoreInterface, maxParallel int, tasks ...playbook.Task) *Deployment {
+	rc, _ := manifest.New("test", mtemplate)
+	web, _ := manifest.New("web", serviceManifest)
+	api, _ := manifest.New("api", strings.Replace(serviceManifest, "name: web", "name: api", 1))
+	bad, _ := manifest.New("bad", "apiVersion: v1\nkind: Node\nmetadata:\n  name: test\n")
+	return &Deployment{
+		Client:    f,
+		Playbook:  playbook.Playbook{ID: "test", Tasks: tasks, MaxParallel: maxParallel},
+		Manifests: map[string]*manifest.Manifest{"test": rc, "web": web, "api": api, "bad": bad},
+	}
+}
+
+func TestDeployRunsIndependentTasksInParallel(t *testing.T) {
+	testcases := []struct {
+		maxParallel int
+		expectedMax int
+	}{
+		{2, 2},
+		{1, 1},
+	}
+	for _, testcase := range testcases {
+		creates := &concurrentCreates{}
+		f := &fake.FakeCore{Fake: &core.Fake{}}
+		f.AddReactor("get", "*", notFoundReaction)
+		d := newGraphDeployment(slowServices{f, creates}, testcase.maxParallel,
+			playbook.Task{Name: "Controller", Manifests: []string{"test"}, DependsOn: []string{"Web"}},
+			playbook.Task{Name: "Web", Manifests: []string{"web"}, DependsOn: []string{}},
+			playbook.Task{Name: "Migrate", Manifests: []string{"test"}, When: "new_deployment", DependsOn: []string{}},
+			playbook.Task{Name: "API", Manifests: []string{"api"}, DependsOn: []string{}},
+		)
+
+		result, err := d.Deploy()
+		assert.Nil(t, err)
+		assert.Equal(t, testcase.expectedMax, creates.max, "max_parallel %d", testcase.maxParallel)
+		names := make([]string, len(result.Tasks))
+		for n, task := range result.Tasks {
+			names[n] = task.Name
+		}
+		assert.Equal(t, []string{"Controller", "Web", "Migrate", "API"}, names)
+		assert.True(t, result.Tasks[2].Skipped)
+	}
+}
+
+func TestDeployNamesManifestsThatFailToDecode(t *testing.T) {
+	d := newGraphDeployment(&fake.FakeCore{Fake: &core.Fake{}}, 1,
+		playbook.Task{Name: "Broken", Manifests: []string{"test", "broken"}},
+	)
+	d.Manifests["broken"], _ = manifest.New("broken", "apiVersion: v1\nkind: Widget\nmetadata:\n  name: test\n")
+	_, err := d.Deploy()
+	if assert.IsType(t, TaskError{}, err) {
+		assert.IsType(t, ManifestError{}, err.(TaskError).Err)
+		assert.Equal(t, "broken", err.(TaskError).Err.(ManifestError).Manifest)
+	}
+
+	d.Playbook.Tasks = []playbook.Task{{Name: "Migrate", PodManifest: "broken"}}
+	_, err = d.Deploy()
+	if assert.IsType(t, TaskError{}, err) {
+		assert.Contains(t, err.Error(), "manifest broken: ")
+	}
+}
+
+func TestDeployStopsSchedulingAfterFailure(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+	s := store.NewMemory()
+	d := newGraphDeployment(f, 1,
+		playbook.Task{Name: "Break", Manifests: []string{"bad"}},
+		playbook.Task{Name: "After", Manifests: []string{"test"}, DependsOn: []string{"Break"}},
+		playbook.Task{Name: "API", Manifests: []string{"api"}, DependsOn: []string{}},
+	)
+	d.Store = s
+	d.InstanceID = "1"
+	d.Revision = 1
+
+	result, err := d.Deploy()
+	assert.Equal(t, TaskError{Task: "Break", Err: ManifestError{Manifest: "bad", Err: UnsupportedKindError{Kind: "Node"}}}, err)
+	if assert.Len(t, result.Tasks, 1) {
+		assert.Equal(t, "Break", result.Tasks[0].Name)
+	}
+	for _, action := range f.Actions() {
+		assert.NotEqual(t, "create", action.GetVerb())
+	}
+	rev, err := GetRevision(s, "test", "1", 1)
+	assert.Nil(t, err)
+	if assert.Len(t, rev.Tasks, 1) {
+		assert.Equal(t, OutcomeFailed, rev.Tasks[0].Outcome)
+	}
+}
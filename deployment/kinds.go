@@ -0,0 +1,166 @@
+package deployment
+
+import (
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/meta"
+	"k8s.io/kubernetes/pkg/api/v1"
+	coreclient "k8s.io/kubernetes/pkg/client/typed/generated/core/v1"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
+)
+
+// UnsupportedKindError is returned when a manifest decodes to a kind that
+// Broadway does not know how to deploy
+type UnsupportedKindError struct {
+	Kind string
+}
+
+func (e UnsupportedKindError) Error() string {
+	return fmt.Sprintf("Unsupported manifest kind: %s", e.Kind)
+}
+
+// kindOf returns the Kind a decoded object was declared with
+func kindOf(object runtime.Object) string {
+	return object.GetObjectKind().GroupVersionKind().Kind
+}
+
+// nameOf returns the metadata name of a decoded object
+func nameOf(object runtime.Object) string {
+	m, err := meta.Accessor(object)
+	if err != nil {
+		return ""
+	}
+	return m.GetName()
+}
+
+// createObject dispatches object to the typed client matching its kind
+func createObject(client coreclient.CoreInterface, namespace string, object runtime.Object) error {
+	var err error
+	switch o := object.(type) {
+	case *v1.ReplicationController:
+		_, err = client.ReplicationControllers(namespace).Create(o)
+	case *v1.Service:
+		_, err = client.Services(namespace).Create(o)
+	case *v1.Pod:
+		_, err = client.Pods(namespace).Create(o)
+	case *v1.Secret:
+		_, err = client.Secrets(namespace).Create(o)
+	case *v1.ConfigMap:
+		_, err = client.ConfigMaps(namespace).Create(o)
+	case *v1.ServiceAccount:
+		_, err = client.ServiceAccounts(namespace).Create(o)
+	case *v1.Endpoints:
+		_, err = client.Endpoints(namespace).Create(o)
+	case *v1.PersistentVolumeClaim:
+		var claims claimInterface
+		claims, err = persistentVolumeClaims(client, namespace)
+		if err != nil {
+			return err
+		}
+		_, err = claims.Create(o)
+	default:
+		return UnsupportedKindError{Kind: kindOf(object)}
+	}
+	return err
+}
+
+// updateObject dispatches object to the typed client matching its kind
+func updateObject(client coreclient.CoreInterface, namespace string, object runtime.Object) error {
+	var err error
+	switch o := object.(type) {
+	case *v1.ReplicationController:
+		_, err = client.ReplicationControllers(namespace).Update(o)
+	case *v1.Service:
+		_, err = client.Services(namespace).Update(o)
+	case *v1.Pod:
+		_, err = client.Pods(namespace).Update(o)
+	case *v1.Secret:
+		_, err = client.Secrets(namespace).Update(o)
+	case *v1.ConfigMap:
+		_, err = client.ConfigMaps(namespace).Update(o)
+	case *v1.ServiceAccount:
+		_, err = client.ServiceAccounts(namespace).Update(o)
+	case *v1.Endpoints:
+		_, err = client.Endpoints(namespace).Update(o)
+	case *v1.PersistentVolumeClaim:
+		var claims claimInterface
+		claims, err = persistentVolumeClaims(client, namespace)
+		if err != nil {
+			return err
+		}
+		_, err = claims.Update(o)
+	default:
+		return UnsupportedKindError{Kind: kindOf(object)}
+	}
+	return err
+}
+
+// getObject fetches the live object with the same kind and name as object
+func getObject(client coreclient.CoreInterface, namespace string, object runtime.Object) (runtime.Object, error) {
+	name := nameOf(object)
+	switch object.(type) {
+	case *v1.ReplicationController:
+		return client.ReplicationControllers(namespace).Get(name)
+	case *v1.Service:
+		return client.Services(namespace).Get(name)
+	case *v1.Pod:
+		return client.Pods(namespace).Get(name)
+	case *v1.Secret:
+		return client.Secrets(namespace).Get(name)
+	case *v1.ConfigMap:
+		return client.ConfigMaps(namespace).Get(name)
+	case *v1.ServiceAccount:
+		return client.ServiceAccounts(namespace).Get(name)
+	case *v1.Endpoints:
+		return client.Endpoints(namespace).Get(name)
+	case *v1.PersistentVolumeClaim:
+		claims, err := persistentVolumeClaims(client, namespace)
+		if err != nil {
+			return nil, err
+		}
+		return claims.Get(name)
+	}
+	return nil, UnsupportedKindError{Kind: kindOf(object)}
+}
+
+// deleteObject deletes the live object with the same kind and name as
+// object. The pods of a replication controller are deleted with it.
+func deleteObject(client coreclient.CoreInterface, namespace string, object runtime.Object) error {
+	name := nameOf(object)
+	switch o := object.(type) {
+	case *v1.ReplicationController:
+		if err := client.ReplicationControllers(namespace).Delete(name, nil); err != nil {
+			return err
+		}
+		selector := o.Spec.Selector
+		if len(selector) == 0 && o.Spec.Template != nil {
+			selector = o.Spec.Template.Labels
+		}
+		if len(selector) == 0 {
+			return nil
+		}
+		options := api.ListOptions{LabelSelector: labels.SelectorFromSet(selector)}
+		return client.Pods(namespace).DeleteCollection(nil, options)
+	case *v1.Service:
+		return client.Services(namespace).Delete(name, nil)
+	case *v1.Pod:
+		return client.Pods(namespace).Delete(name, nil)
+	case *v1.Secret:
+		return client.Secrets(namespace).Delete(name, nil)
+	case *v1.ConfigMap:
+		return client.ConfigMaps(namespace).Delete(name, nil)
+	case *v1.ServiceAccount:
+		return client.ServiceAccounts(namespace).Delete(name, nil)
+	case *v1.Endpoints:
+		return client.Endpoints(namespace).Delete(name, nil)
+	case *v1.PersistentVolumeClaim:
+		claims, err := persistentVolumeClaims(client, namespace)
+		if err != nil {
+			return err
+		}
+		return claims.Delete(name)
+	}
+	return UnsupportedKindError{Kind: kindOf(object)}
+}
@@ -0,0 +1,126 @@
+package deployment
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/kubernetes/pkg/client/restclient"
+)
+
+// kubeconfig holds the parts of a kubectl config file needed to reach a
+// cluster
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			Username              string `yaml:"username"`
+			Password              string `yaml:"password"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// loadKubeconfig reads the kubectl config file at path and returns a
+// restclient.Config for the named context, or the file's current-context if
+// context is empty. Relative certificate paths are resolved against the
+// directory holding the file.
+func loadKubeconfig(path, context string) (*restclient.Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(content, &kc); err != nil {
+		return nil, fmt.Errorf("Failed to parse kubeconfig %s: %s", path, err)
+	}
+	if context == "" {
+		context = kc.CurrentContext
+	}
+
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == context {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("Context %q not found in kubeconfig %s", context, path)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+
+	cfg := &restclient.Config{}
+	found = false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		cfg.Host = c.Cluster.Server
+		cfg.Insecure = c.Cluster.InsecureSkipTLSVerify
+		cfg.CAFile = resolve(c.Cluster.CertificateAuthority)
+		if cfg.CAData, err = decodeKubeconfigData(c.Cluster.CertificateAuthorityData); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("Cluster %q not found in kubeconfig %s", clusterName, path)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		cfg.BearerToken = u.User.Token
+		cfg.Username = u.User.Username
+		cfg.Password = u.User.Password
+		cfg.CertFile = resolve(u.User.ClientCertificate)
+		cfg.KeyFile = resolve(u.User.ClientKey)
+		if cfg.CertData, err = decodeKubeconfigData(u.User.ClientCertificateData); err != nil {
+			return nil, err
+		}
+		if cfg.KeyData, err = decodeKubeconfigData(u.User.ClientKeyData); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+func decodeKubeconfigData(data string) ([]byte, error) {
+	if data == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(data)
+}
@@ -0,0 +1,86 @@
+package deployment
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: staging
+clusters:
+- name: staging
+  cluster:
+    server: https://staging.example.com
+    certificate-authority: ca.pem
+- name: qa
+  cluster:
+    server: https://qa.example.com
+    certificate-authority-data: Y2EtZGF0YQ==
+users:
+- name: deployer
+  user:
+    token: s3cret
+- name: admin
+  user:
+    client-certificate: /etc/certs/admin.pem
+    client-key-data: a2V5LWRhdGE=
+contexts:
+- name: staging
+  context:
+    cluster: staging
+    user: deployer
+- name: qa
+  context:
+    cluster: qa
+    user: admin
+`
+
+func writeTestKubeconfig(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "broadway-kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadKubeconfigCurrentContext(t *testing.T) {
+	path, cleanup := writeTestKubeconfig(t)
+	defer cleanup()
+
+	cfg, err := loadKubeconfig(path, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://staging.example.com", cfg.Host)
+	assert.Equal(t, "s3cret", cfg.BearerToken)
+	assert.Equal(t, filepath.Join(filepath.Dir(path), "ca.pem"), cfg.CAFile)
+}
+
+func TestLoadKubeconfigNamedContext(t *testing.T) {
+	path, cleanup := writeTestKubeconfig(t)
+	defer cleanup()
+
+	cfg, err := loadKubeconfig(path, "qa")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://qa.example.com", cfg.Host)
+	assert.Equal(t, []byte("ca-data"), cfg.CAData)
+	assert.Equal(t, "/etc/certs/admin.pem", cfg.CertFile)
+	assert.Equal(t, []byte("key-data"), cfg.KeyData)
+	assert.Equal(t, "", cfg.BearerToken)
+}
+
+func TestLoadKubeconfigMissingContext(t *testing.T) {
+	path, cleanup := writeTestKubeconfig(t)
+	defer cleanup()
+
+	_, err := loadKubeconfig(path, "production")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "production")
+}
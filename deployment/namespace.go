@@ -0,0 +1,64 @@
+package deployment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/v1"
+	coreclient "k8s.io/kubernetes/pkg/client/typed/generated/core/v1"
+	"k8s.io/kubernetes/pkg/util/validation"
+)
+
+// namespacePrefix keeps instance namespaces apart from system namespaces such
+// as kube-system
+const namespacePrefix = "broadway-"
+
+var invalidNamespaceChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// NamespaceFor returns the Kubernetes namespace an instance is deployed into.
+// The name is a DNS-1123 label derived from the playbook and instance IDs,
+// truncated if too long, and always suffixed with a hash of both IDs: "web"
+// and "pr-1" must not share a namespace with "web-pr" and "1".
+func NamespaceFor(playbookID, instanceID string) string {
+	name := strings.ToLower(namespacePrefix + playbookID + "-" + instanceID)
+	name = invalidNamespaceChars.ReplaceAllString(name, "-")
+	name = strings.Trim(name, "-")
+	sum := sha256.Sum256([]byte(playbookID + "/" + instanceID))
+	suffix := hex.EncodeToString(sum[:])[:8]
+	if max := validation.DNS1123LabelMaxLength - len(suffix) - 1; len(name) > max {
+		name = strings.TrimRight(name[:max], "-")
+	}
+	return name + "-" + suffix
+}
+
+// Teardown deletes the namespace an instance was deployed into, and with it
+// every object its playbook created. A namespace that does not exist is not
+// an error.
+func Teardown(client coreclient.CoreInterface, namespace string) error {
+	err := client.Namespaces().Delete(namespace, nil)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ensureNamespace creates the namespace unless it already exists
+func ensureNamespace(client coreclient.CoreInterface, name string) error {
+	_, err := client.Namespaces().Get(name)
+	if err == nil {
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	ns := &v1.Namespace{}
+	ns.Name = name
+	_, err = client.Namespaces().Create(ns)
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
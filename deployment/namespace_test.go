@@ -0,0 +1,61 @@
+package deployment
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/client/testing/core"
+	"k8s.io/kubernetes/pkg/client/typed/generated/core/v1/fake"
+	"k8s.io/kubernetes/pkg/util/validation"
+)
+
+func TestNamespaceFor(t *testing.T) {
+	assert.True(t, strings.HasPrefix(NamespaceFor("web", "pr-12"), "broadway-web-pr-12-"))
+	assert.True(t, strings.HasPrefix(NamespaceFor("Web", "feature/X"), "broadway-web-feature-x-"))
+	assert.NotEqual(t, NamespaceFor("web", "a"), NamespaceFor("web", "b"))
+	assert.NotEqual(t, NamespaceFor("web", "pr-1"), NamespaceFor("web-pr", "1"))
+	assert.NotEqual(t, NamespaceFor("web", "feature-x"), NamespaceFor("Web", "feature/X"))
+
+	long := strings.Repeat("a", 80)
+	name := NamespaceFor("web", long)
+	assert.True(t, validation.IsDNS1123Label(name), name)
+	assert.NotEqual(t, name, NamespaceFor("web", long+"b"))
+}
+
+func TestEnsureNamespaceCreatesMissingNamespace(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "namespaces", notFoundReaction)
+
+	err := ensureNamespace(f, "broadway-web-1")
+	assert.Nil(t, err)
+	actions := f.Actions()
+	if assert.Len(t, actions, 2) {
+		assert.Equal(t, "create", actions[1].GetVerb())
+		assert.Equal(t, "namespaces", actions[1].GetResource())
+	}
+}
+
+func TestEnsureNamespaceReusesExistingNamespace(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+
+	err := ensureNamespace(f, "broadway-web-1")
+	assert.Nil(t, err)
+	assert.Len(t, f.Actions(), 1)
+}
+
+func TestTeardown(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+
+	err := Teardown(f, "broadway-web-1")
+	assert.Nil(t, err)
+	actions := f.Actions()
+	if assert.Len(t, actions, 1) {
+		assert.Equal(t, "delete", actions[0].GetVerb())
+		assert.Equal(t, "namespaces", actions[0].GetResource())
+	}
+
+	f.AddReactor("delete", "namespaces", notFoundReaction)
+	err = Teardown(f, "broadway-web-1")
+	assert.Nil(t, err)
+}
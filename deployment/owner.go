@@ -0,0 +1,94 @@
+package deployment
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api/meta"
+	"k8s.io/kubernetes/pkg/runtime"
+)
+
+// Labels and annotations Broadway sets on every object it deploys, so that
+// the objects of an instance can be found with a label selector, e.g.
+// broadway/playbook=web,broadway/instance=master
+const (
+	PlaybookLabel      = "broadway/playbook"
+	InstanceLabel      = "broadway/instance"
+	TaskLabel          = "broadway/task"
+	RevisionAnnotation = "broadway/revision"
+)
+
+// labelValueMaxLength is the longest a Kubernetes label value may be
+const labelValueMaxLength = 63
+
+var labelValueInvalid = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// Owner identifies the instance deployment objects are deployed for
+type Owner struct {
+	PlaybookID string
+	InstanceID string
+	Revision   int
+}
+
+// owner returns the Owner of the objects the deployment applies
+func (d *Deployment) owner() Owner {
+	return Owner{PlaybookID: d.Playbook.ID, InstanceID: d.InstanceID, Revision: d.Revision}
+}
+
+// labelValue turns s into a valid label value: alphanumerics, dashes,
+// underscores and dots, starting and ending with an alphanumeric, at most 63
+// characters. "Deploy Web" becomes "Deploy-Web".
+func labelValue(s string) string {
+	value := labelValueInvalid.ReplaceAllString(s, "-")
+	if len(value) > labelValueMaxLength {
+		value = value[:labelValueMaxLength]
+	}
+	return strings.Trim(value, "-_.")
+}
+
+// setOwnerLabels labels object with its owner and the task deploying it.
+// Labels the manifest sets under the same keys are replaced. Empty owner
+// fields are left out.
+func setOwnerLabels(object runtime.Object, owner Owner, task string) error {
+	m, err := meta.Accessor(object)
+	if err != nil {
+		return err
+	}
+	labels := m.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for key, value := range map[string]string{
+		PlaybookLabel: owner.PlaybookID,
+		InstanceLabel: owner.InstanceID,
+		TaskLabel:     task,
+	} {
+		if value := labelValue(value); value != "" {
+			labels[key] = value
+		}
+	}
+	m.SetLabels(labels)
+	return nil
+}
+
+// setRevisionAnnotation records the revision applying object on it. It is
+// set after the object is hashed, so that a manifest applied again by a
+// later revision is still unchanged and the live object keeps the revision
+// that last changed it.
+func setRevisionAnnotation(object runtime.Object, revision int) error {
+	if revision == 0 {
+		return nil
+	}
+	m, err := meta.Accessor(object)
+	if err != nil {
+		return err
+	}
+	annotations := m.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[RevisionAnnotation] = strconv.Itoa(revision)
+	m.SetAnnotations(annotations)
+	return nil
+}
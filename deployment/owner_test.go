@@ -0,0 +1,72 @@
+package deployment
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/v1"
+
+	"github.com/namely/broadway/playbook"
+)
+
+func TestLabelValue(t *testing.T) {
+	testcases := []struct {
+		value    string
+		expected string
+	}{
+		{"web", "web"},
+		{"Deploy Web", "Deploy-Web"},
+		{" Run migrations! ", "Run-migrations"},
+		{"feature/ABC_1.2", "feature-ABC_1.2"},
+		{strings.Repeat("a", 70), strings.Repeat("a", 63)},
+	}
+	for _, testcase := range testcases {
+		assert.Equal(t, testcase.expected, labelValue(testcase.value), testcase.value)
+	}
+}
+
+func TestStepSetsOwnerLabels(t *testing.T) {
+	manifest := `apiVersion: v1
+kind: ReplicationController
+metadata:
+  name: redis
+  labels:
+    app: redis
+    broadway/task: other
+spec:
+  selector:
+    name: redis
+  template:
+    metadata:
+      labels:
+        name: redis
+`
+	owner := Owner{PlaybookID: "web", InstanceID: "pr-12", Revision: 3}
+	step, err := NewDefaultStep(nil, owner, playbook.Task{Name: "Deploy Redis"}, "default", manifest)
+	assert.Nil(t, err)
+
+	rc := step.object.(*v1.ReplicationController)
+	assert.Equal(t, map[string]string{
+		"app":         "redis",
+		PlaybookLabel: "web",
+		InstanceLabel: "pr-12",
+		TaskLabel:     "Deploy-Redis",
+	}, rc.Labels)
+	assert.Equal(t, "3", rc.Annotations[RevisionAnnotation])
+	assert.Equal(t, map[string]string{"name": "redis"}, rc.Spec.Template.Labels)
+
+	owner.Revision = 4
+	next, err := NewDefaultStep(nil, owner, playbook.Task{Name: "Deploy Redis"}, "default", manifest)
+	assert.Nil(t, err)
+	assert.Equal(t, step.hash, next.hash, "the revision should not change the hash")
+}
+
+func TestPodStepSetsOwnerLabels(t *testing.T) {
+	task := playbook.Task{Name: "Migrate", PodManifest: "migrate"}
+	step, err := NewPodStep(nil, Owner{PlaybookID: "web", InstanceID: "pr-12", Revision: 3}, task, "default", podTemplate)
+	assert.Nil(t, err)
+	assert.Equal(t, "Migrate", step.pod.Labels[TaskLabel])
+	assert.Equal(t, "pr-12", step.pod.Labels[InstanceLabel])
+	assert.Equal(t, "3", step.pod.Annotations[RevisionAnnotation])
+}
@@ -0,0 +1,255 @@
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/meta"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/runtime"
+
+	"github.com/namely/broadway/instance"
+	"github.com/namely/broadway/playbook"
+)
+
+// Action describes what deploying an object would do to the cluster
+type Action string
+
+const (
+	// ActionCreate means the object does not exist and would be created
+	ActionCreate Action = "create"
+	// ActionUpdate means the live object differs and would be replaced
+	ActionUpdate Action = "update"
+	// ActionNoop means the live object already matches the manifest
+	ActionNoop Action = "no-op"
+	// ActionRun means a pod manifest's pod would be run
+	ActionRun Action = "run"
+)
+
+// sensitiveValue replaces the values of Secrets in a plan
+const sensitiveValue = "(sensitive)"
+
+// FieldDiff is one field that differs between a live object and its manifest.
+// Path names the field, e.g. spec.template.spec.containers[0].image.
+type FieldDiff struct {
+	Path    string      `json:"path"`
+	Live    interface{} `json:"live"`
+	Planned interface{} `json:"planned"`
+}
+
+// ObjectPlan reports what deploying one manifest object would do
+type ObjectPlan struct {
+	Kind   string      `json:"kind"`
+	Name   string      `json:"name"`
+	Action Action      `json:"action"`
+	Diff   []FieldDiff `json:"diff,omitempty"`
+}
+
+// TaskPlan collects the object plans of one playbook task
+type TaskPlan struct {
+	Name    string       `json:"name"`
+	Skipped bool         `json:"skipped,omitempty"`
+	Objects []ObjectPlan `json:"objects,omitempty"`
+}
+
+// Plan reports what a deployment would change, task by task
+type Plan struct {
+	Tasks []TaskPlan `json:"tasks"`
+}
+
+// PlanInstance plans the next deployment of the instance with its vars,
+// overridden by vars, and the playbook's defaults. Nothing is written to the
+// cluster or the store.
+func (d *Deployment) PlanInstance(i instance.Instance, vars map[string]string) (*Plan, error) {
+	attrs := i.Attributes()
+	d.InstanceID = attrs.ID
+	d.Revision = attrs.Revision + 1
+	d.Created = attrs.Created
+	d.Namespace = attrs.Namespace
+	if d.Namespace == "" {
+		d.Namespace = NamespaceFor(attrs.PlaybookID, attrs.ID)
+	}
+	merged := map[string]string{}
+	for k, v := range attrs.Vars {
+		merged[k] = v
+	}
+	for k, v := range vars {
+		merged[k] = v
+	}
+	d.Variables = d.Playbook.WithDefaults(merged)
+	d.FirstDeploy = attrs.Deployed == ""
+	return d.Plan()
+}
+
+// Plan renders every manifest and compares it to the live object, without
+// making any write calls against the cluster
+func (d *Deployment) Plan() (*Plan, error) {
+	plan := &Plan{}
+	state := playbook.State{Vars: d.Variables, FirstDeploy: d.FirstDeploy}
+	for _, task := range d.Playbook.Tasks {
+		run, err := task.ShouldRun(state)
+		if err != nil {
+			return plan, TaskError{Task: task.Name, Err: err}
+		}
+		if !run {
+			plan.Tasks = append(plan.Tasks, TaskPlan{Name: task.Name, Skipped: true})
+			continue
+		}
+		taskPlan, err := d.planTask(task)
+		plan.Tasks = append(plan.Tasks, taskPlan)
+		if err != nil {
+			return plan, TaskError{Task: task.Name, Err: err}
+		}
+	}
+	return plan, nil
+}
+
+func (d *Deployment) planTask(task playbook.Task) (TaskPlan, error) {
+	plan := TaskPlan{Name: task.Name}
+	for _, name := range task.Manifests {
+		documents, err := d.renderDocuments(name)
+		if err != nil {
+			return plan, err
+		}
+		for _, document := range documents {
+			step, err := NewDefaultStep(d.Client, d.owner(), task, d.namespace(), document)
+			if err != nil {
+				return plan, ManifestError{Manifest: name, Err: err}
+			}
+			objectPlan, err := planObject(step)
+			if err != nil {
+				return plan, objectError(name, err)
+			}
+			plan.Objects = append(plan.Objects, objectPlan)
+		}
+	}
+
+	if task.PodManifest == "" {
+		return plan, nil
+	}
+	pod, err := d.renderPod(task.PodManifest)
+	if err != nil {
+		return plan, err
+	}
+	step, err := NewPodStep(d.Client, d.owner(), task, d.namespace(), pod)
+	if err != nil {
+		return plan, err
+	}
+	plan.Objects = append(plan.Objects, ObjectPlan{Kind: "Pod", Name: step.pod.Name, Action: ActionRun})
+	return plan, nil
+}
+
+// planObject compares the step's object to the live one the way applyObject
+// would, reporting the fields an update would change
+func planObject(s *DefaultStep) (ObjectPlan, error) {
+	plan := ObjectPlan{Kind: kindOf(s.object), Name: nameOf(s.object)}
+	live, err := getObject(s.client, s.namespace, s.object)
+	if errors.IsNotFound(err) {
+		plan.Action = ActionCreate
+		return plan, nil
+	}
+	if err != nil {
+		return plan, err
+	}
+
+	lm, err := meta.Accessor(live)
+	if err != nil {
+		return plan, err
+	}
+	if lm.GetAnnotations()[appliedHashAnnotation] == s.hash {
+		plan.Action = ActionNoop
+		return plan, nil
+	}
+	plan.Action = ActionUpdate
+	preserveLiveFields(s.object, live)
+	plan.Diff, err = diffObjects(live, s.object)
+	return plan, err
+}
+
+// diffObjects lists the fields set in planned that differ in live. Type
+// metadata, which the client does not fill in on live objects, fields only
+// the cluster sets, like status, and Broadway's own annotations are left
+// out. The values of Secrets are hidden.
+func diffObjects(live, planned runtime.Object) ([]FieldDiff, error) {
+	liveFields, err := objectFields(live)
+	if err != nil {
+		return nil, err
+	}
+	plannedFields, err := objectFields(planned)
+	if err != nil {
+		return nil, err
+	}
+	for _, fields := range []map[string]interface{}{liveFields, plannedFields} {
+		delete(fields, "apiVersion")
+		delete(fields, "kind")
+		delete(fields, "status")
+		if metadata, ok := fields["metadata"].(map[string]interface{}); ok {
+			if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+				delete(annotations, appliedHashAnnotation)
+				delete(annotations, RevisionAnnotation)
+			}
+		}
+	}
+
+	var diff []FieldDiff
+	diffFields("", liveFields, plannedFields, &diff)
+	if _, ok := planned.(*v1.Secret); ok {
+		for n := range diff {
+			if strings.HasPrefix(diff[n].Path, "data") || strings.HasPrefix(diff[n].Path, "stringData") {
+				diff[n].Live = sensitiveValue
+				diff[n].Planned = sensitiveValue
+			}
+		}
+	}
+	return diff, nil
+}
+
+func objectFields(object runtime.Object) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	err = json.Unmarshal(encoded, &fields)
+	return fields, err
+}
+
+func diffFields(path string, live, planned interface{}, diff *[]FieldDiff) {
+	switch p := planned.(type) {
+	case nil:
+		// Fields the manifest leaves unset keep their live value
+	case map[string]interface{}:
+		l, _ := live.(map[string]interface{})
+		keys := make([]string, 0, len(p))
+		for key := range p {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			diffFields(field, l[key], p[key], diff)
+		}
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(p) {
+			if !reflect.DeepEqual(live, planned) {
+				*diff = append(*diff, FieldDiff{Path: path, Live: live, Planned: planned})
+			}
+			return
+		}
+		for n := range p {
+			diffFields(fmt.Sprintf("%s[%d]", path, n), l[n], p[n], diff)
+		}
+	default:
+		if !reflect.DeepEqual(live, planned) {
+			*diff = append(*diff, FieldDiff{Path: path, Live: live, Planned: planned})
+		}
+	}
+}
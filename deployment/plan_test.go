@@ -0,0 +1,175 @@
+package deployment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/client/testing/core"
+	"k8s.io/kubernetes/pkg/client/typed/generated/core/v1/fake"
+	"k8s.io/kubernetes/pkg/runtime"
+
+	"github.com/namely/broadway/instance"
+	"github.com/namely/broadway/manifest"
+	"github.com/namely/broadway/playbook"
+)
+
+var servicePortManifest = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - name: http
+    port: {{.port}}
+  selector:
+    name: web
+`
+
+var secretManifest = `apiVersion: v1
+kind: Secret
+metadata:
+  name: creds
+data:
+  password: {{.password}}
+`
+
+func newPlanDeployment(f *fake.FakeCore) *Deployment {
+	web, _ := manifest.New("web", servicePortManifest)
+	rc, _ := manifest.New("test", mtemplate)
+	return &Deployment{
+		Client: f,
+		Playbook: playbook.Playbook{
+			ID: "test",
+			Tasks: []playbook.Task{
+				{Name: "Service", Manifests: []string{"web"}},
+				{Name: "Controller", Manifests: []string{"test"}},
+				{Name: "Migrate", Manifests: []string{"test"}, When: "redeployment"},
+			},
+		},
+		Manifests: map[string]*manifest.Manifest{"web": web, "test": rc},
+	}
+}
+
+func liveService(f *fake.FakeCore, annotations map[string]string) {
+	f.AddReactor("get", "services", func(action core.Action) (bool, runtime.Object, error) {
+		live := &v1.Service{}
+		live.Name = "web"
+		live.ResourceVersion = "7"
+		live.Annotations = annotations
+		live.Spec.ClusterIP = "10.0.0.5"
+		live.Spec.Ports = []v1.ServicePort{{Name: "http", Port: 80, NodePort: 30080}}
+		live.Spec.Selector = map[string]string{"name": "web"}
+		return true, live, nil
+	})
+}
+
+func TestPlanInstance(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	liveService(f, nil)
+	f.AddReactor("get", "*", notFoundReaction)
+	d := newPlanDeployment(f)
+
+	i := instance.New(nil, &instance.Attributes{
+		PlaybookID: "test",
+		ID:         "plan",
+		Vars:       map[string]string{"port": "80"},
+	})
+	plan, err := d.PlanInstance(i, map[string]string{"port": "8080"})
+	assert.Nil(t, err)
+	assert.Equal(t, NamespaceFor("test", "plan"), d.Namespace)
+	assert.Equal(t, []TaskPlan{
+		{
+			Name: "Service",
+			Objects: []ObjectPlan{{
+				Kind:   "Service",
+				Name:   "web",
+				Action: ActionUpdate,
+				Diff: []FieldDiff{
+					{Path: "metadata.labels.broadway/instance", Planned: "plan"},
+					{Path: "metadata.labels.broadway/playbook", Planned: "test"},
+					{Path: "metadata.labels.broadway/task", Planned: "Service"},
+					{Path: "spec.ports[0].port", Live: float64(80), Planned: float64(8080)},
+				},
+			}},
+		},
+		{
+			Name:    "Controller",
+			Objects: []ObjectPlan{{Kind: "ReplicationController", Name: "test", Action: ActionCreate}},
+		},
+		{Name: "Migrate", Skipped: true},
+	}, plan.Tasks)
+
+	for _, action := range f.Actions() {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+}
+
+func TestPlanInstanceFillsDefaults(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+	d := newPlanDeployment(f)
+	d.Playbook.Vars = []playbook.Var{{Name: "port", Default: "8080"}}
+
+	i := instance.New(nil, &instance.Attributes{PlaybookID: "test", ID: "plan"})
+	_, err := d.PlanInstance(i, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"port": "8080"}, d.Variables)
+}
+
+func TestPlanUnchangedObject(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	step, err := NewDefaultStep(f, Owner{}, playbook.Task{Name: "step"}, "default", serviceManifest)
+	assert.Nil(t, err)
+	liveService(f, map[string]string{appliedHashAnnotation: step.hash})
+
+	plan, err := planObject(step)
+	assert.Nil(t, err)
+	assert.Equal(t, ObjectPlan{Kind: "Service", Name: "web", Action: ActionNoop}, plan)
+}
+
+func TestPlanHidesSecretValues(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "secrets", func(action core.Action) (bool, runtime.Object, error) {
+		live := &v1.Secret{}
+		live.Name = "creds"
+		live.Labels = map[string]string{TaskLabel: "step"}
+		live.Data = map[string][]byte{"password": []byte("old")}
+		return true, live, nil
+	})
+	m, _ := manifest.New("creds", secretManifest)
+	rendered, err := m.Execute(map[string]string{"password": "bmV3"}, manifest.Builtins{})
+	assert.Nil(t, err)
+	step, err := NewDefaultStep(f, Owner{}, playbook.Task{Name: "step"}, "default", rendered)
+	assert.Nil(t, err)
+
+	plan, err := planObject(step)
+	assert.Nil(t, err)
+	assert.Equal(t, ActionUpdate, plan.Action)
+	assert.Equal(t, []FieldDiff{{Path: "data.password", Live: sensitiveValue, Planned: sensitiveValue}}, plan.Diff)
+}
+
+func TestDiffFields(t *testing.T) {
+	live := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "web", "uid": "abc"},
+		"spec": map[string]interface{}{
+			"replicas": float64(1),
+			"ports":    []interface{}{"a"},
+		},
+	}
+	planned := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "web", "creationTimestamp": nil},
+		"spec": map[string]interface{}{
+			"replicas": float64(2),
+			"ports":    []interface{}{"a", "b"},
+			"type":     "NodePort",
+		},
+	}
+	var diff []FieldDiff
+	diffFields("", live, planned, &diff)
+	assert.Equal(t, []FieldDiff{
+		{Path: "spec.ports", Live: []interface{}{"a"}, Planned: []interface{}{"a", "b"}},
+		{Path: "spec.replicas", Live: float64(1), Planned: float64(2)},
+		{Path: "spec.type", Live: nil, Planned: "NodePort"},
+	}, diff)
+}
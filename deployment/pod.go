@@ -0,0 +1,266 @@
+package deployment
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/v1"
+	coreclient "k8s.io/kubernetes/pkg/client/typed/generated/core/v1"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/watch"
+
+	"github.com/namely/broadway/playbook"
+)
+
+// PodTimeout is how long a pod manifest task may take to reach a phase it
+// waits for, unless the task sets a timeout
+var PodTimeout = 10 * time.Minute
+
+// podRetryInterval is how often creating a pod is retried while the pod of
+// a previous run is still terminating
+var podRetryInterval = time.Second
+
+// podPhases maps wait_for values to the pod phases they accept
+var podPhases = map[string]v1.PodPhase{
+	playbook.WaitForSuccess: v1.PodSucceeded,
+	playbook.WaitForFailure: v1.PodFailed,
+	playbook.WaitForRunning: v1.PodRunning,
+}
+
+// PodPhaseError is returned when a pod ends in a phase its task does not wait
+// for
+type PodPhaseError struct {
+	Pod     string
+	Phase   v1.PodPhase
+	WaitFor []string
+}
+
+func (e PodPhaseError) Error() string {
+	return fmt.Sprintf("Pod %s ended in phase %s, expected %s", e.Pod, e.Phase, strings.Join(e.WaitFor, " or "))
+}
+
+// PodTimeoutError is returned when a pod does not reach a phase its task
+// waits for in time
+type PodTimeoutError struct {
+	Pod     string
+	Phase   v1.PodPhase
+	Timeout time.Duration
+}
+
+func (e PodTimeoutError) Error() string {
+	return fmt.Sprintf("Pod %s still in phase %s after %s", e.Pod, e.Phase, e.Timeout)
+}
+
+// PodResult reports how a pod manifest task's pod ran
+type PodResult struct {
+	Name  string      `json:"name"`
+	Phase v1.PodPhase `json:"phase"`
+	Logs  string      `json:"logs,omitempty"`
+	// LogsError is why the logs could not be read, if they could not
+	LogsError string `json:"logs_error,omitempty"`
+}
+
+// podLogsGetter can be implemented by a CoreInterface that can read pod logs
+// itself, e.g. a fake client in tests
+type podLogsGetter interface {
+	PodLogs(namespace, pod, container string) (string, error)
+}
+
+// PodStep runs a task's pod manifest to completion. The pod of a previous
+// run is replaced, and the step waits until the pod reaches one of the
+// phases listed in the task's wait_for.
+type PodStep struct {
+	client    coreclient.CoreInterface
+	task      playbook.Task
+	namespace string
+	pod       *v1.Pod
+	// deadline, when set, is when the attempt at the task ends
+	deadline time.Time
+}
+
+// NewPodStep creates a step that runs manifest, which must describe a Pod,
+// in namespace with client. The pod is labeled with its owner and task.
+func NewPodStep(client coreclient.CoreInterface, owner Owner, task playbook.Task, namespace, manifest string) (*PodStep, error) {
+	object, _, err := deserializer.Decode([]byte(manifest), &groupVersionKind, nil)
+	if err != nil {
+		return nil, ManifestError{Manifest: task.PodManifest, Err: err}
+	}
+	pod, ok := object.(*v1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("Pod manifest %s is a %s, not a Pod", task.PodManifest, kindOf(object))
+	}
+	if pod.Spec.RestartPolicy == "" {
+		pod.Spec.RestartPolicy = v1.RestartPolicyNever
+	}
+	if err := setOwnerLabels(pod, owner, task.Name); err != nil {
+		return nil, err
+	}
+	if err := setRevisionAnnotation(pod, owner.Revision); err != nil {
+		return nil, err
+	}
+	return &PodStep{
+		client:    client,
+		task:      task,
+		namespace: namespace,
+		pod:       pod,
+	}, nil
+}
+
+// Task returns the step task
+func (s *PodStep) Task() playbook.Task {
+	return s.task
+}
+
+// Run creates the pod and waits for it. The result holds the phase the pod
+// was last seen in and the logs of its containers, even when Run fails.
+func (s *PodStep) Run() (PodResult, error) {
+	result := PodResult{Name: s.pod.Name}
+	timeout := waitTimeout(s.task, PodTimeout, s.deadline)
+	deadline := time.Now().Add(timeout)
+
+	created, err := s.create(deadline)
+	if err != nil {
+		return result, err
+	}
+	result.Phase = created.Status.Phase
+
+	result.Phase, err = s.wait(created, timeout, deadline)
+	// Logs that cannot be read do not fail a pod that reached its phase
+	logs, logsErr := s.logs()
+	result.Logs = logs
+	if logsErr != nil {
+		result.LogsError = logsErr.Error()
+	}
+	return result, err
+}
+
+// create replaces any pod left by a previous run with the step's pod
+func (s *PodStep) create(deadline time.Time) (*v1.Pod, error) {
+	return replacePod(s.client, s.namespace, s.pod, deadline)
+}
+
+// replacePod deletes the pod named like pod, if there is one, and creates pod
+// in its place. Creating is retried while the old pod is still terminating,
+// until deadline passes.
+func replacePod(client coreclient.CoreInterface, namespace string, pod *v1.Pod, deadline time.Time) (*v1.Pod, error) {
+	pods := client.Pods(namespace)
+	err := pods.Delete(pod.Name, api.NewDeleteOptions(0))
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	for {
+		created, err := pods.Create(pod)
+		if !errors.IsAlreadyExists(err) {
+			return created, err
+		}
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(podRetryInterval)
+	}
+}
+
+// wait watches the pod until it reaches a phase the task waits for, ends in
+// another phase, or the deadline, timeout from the start, passes
+func (s *PodStep) wait(pod *v1.Pod, timeout time.Duration, deadline time.Time) (v1.PodPhase, error) {
+	phase := pod.Status.Phase
+	if done, err := s.reached(phase); done {
+		return phase, err
+	}
+
+	w, err := s.client.Pods(s.namespace).Watch(api.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", s.pod.Name),
+		ResourceVersion: pod.ResourceVersion,
+	})
+	if err != nil {
+		return phase, err
+	}
+	defer w.Stop()
+
+	expired := time.After(deadline.Sub(time.Now()))
+	for {
+		select {
+		case <-expired:
+			return phase, PodTimeoutError{Pod: s.pod.Name, Phase: phase, Timeout: timeout}
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return phase, fmt.Errorf("Watch of pod %s closed", s.pod.Name)
+			}
+			switch event.Type {
+			case watch.Deleted:
+				return phase, fmt.Errorf("Pod %s was deleted", s.pod.Name)
+			case watch.Error:
+				return phase, errors.FromObject(event.Object)
+			}
+			current, ok := event.Object.(*v1.Pod)
+			if !ok {
+				continue
+			}
+			phase = current.Status.Phase
+			if done, err := s.reached(phase); done {
+				return phase, err
+			}
+		}
+	}
+}
+
+// reached reports whether waiting for the pod is over in phase, and with an
+// error if the phase is final but not one the task waits for. A pod that
+// succeeded satisfies waiting for running, since it has run.
+func (s *PodStep) reached(phase v1.PodPhase) (bool, error) {
+	waitFor := s.waitFor()
+	for _, w := range waitFor {
+		if podPhases[w] == phase {
+			return true, nil
+		}
+		if w == playbook.WaitForRunning && phase == v1.PodSucceeded {
+			return true, nil
+		}
+	}
+	if phase == v1.PodSucceeded || phase == v1.PodFailed {
+		return true, PodPhaseError{Pod: s.pod.Name, Phase: phase, WaitFor: waitFor}
+	}
+	return false, nil
+}
+
+func (s *PodStep) waitFor() []string {
+	if len(s.task.WaitFor) == 0 {
+		return []string{playbook.WaitForSuccess}
+	}
+	return s.task.WaitFor
+}
+
+// logs collects the logs of each container of the pod. Pods with several
+// containers have each container's logs headed by its name.
+func (s *PodStep) logs() (string, error) {
+	containers := s.pod.Spec.Containers
+	var buf bytes.Buffer
+	for _, c := range containers {
+		logs, err := podLogs(s.client, s.namespace, s.pod.Name, c.Name)
+		if err != nil {
+			return buf.String(), err
+		}
+		if len(containers) > 1 {
+			fmt.Fprintf(&buf, "==> %s <==\n", c.Name)
+		}
+		buf.WriteString(logs)
+	}
+	return buf.String(), nil
+}
+
+// podLogs reads the logs of a pod container. Clients that cannot read logs
+// return none.
+func podLogs(client coreclient.CoreInterface, namespace, pod, container string) (string, error) {
+	switch c := client.(type) {
+	case podLogsGetter:
+		return c.PodLogs(namespace, pod, container)
+	case *coreclient.CoreClient:
+		raw, err := c.Pods(namespace).GetLogs(pod, &v1.PodLogOptions{Container: container}).DoRaw()
+		return string(raw), err
+	}
+	return "", nil
+}
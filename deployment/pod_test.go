@@ -0,0 +1,207 @@
+package deployment
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/client/testing/core"
+	"k8s.io/kubernetes/pkg/client/typed/generated/core/v1/fake"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+
+	"github.com/namely/broadway/manifest"
+	"github.com/namely/broadway/playbook"
+	"github.com/namely/broadway/store"
+)
+
+const podTemplate = `apiVersion: v1
+kind: Pod
+metadata:
+  name: migrate
+spec:
+  containers:
+    - name: migrate
+      image: busybox
+`
+
+// fakePodLogsCore adds pod logs to the vendored fake client
+type fakePodLogsCore struct {
+	*fake.FakeCore
+	logs    map[string]string
+	logsErr error
+}
+
+func (c *fakePodLogsCore) PodLogs(namespace, pod, container string) (string, error) {
+	return c.logs[pod+"/"+container], c.logsErr
+}
+
+// newFakePodCore returns a fake client whose pod watch emits phases in order
+func newFakePodCore(phases ...v1.PodPhase) *fakePodLogsCore {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		pod := action.(core.CreateAction).GetObject().(*v1.Pod)
+		pod.Status.Phase = v1.PodPending
+		return true, pod, nil
+	})
+	f.AddWatchReactor("pods", func(action core.Action) (bool, watch.Interface, error) {
+		w := watch.NewFake()
+		go func() {
+			for _, phase := range phases {
+				w.Modify(&v1.Pod{
+					ObjectMeta: v1.ObjectMeta{Name: "migrate"},
+					Status:     v1.PodStatus{Phase: phase},
+				})
+			}
+		}()
+		return true, w, nil
+	})
+	return &fakePodLogsCore{FakeCore: f, logs: map[string]string{"migrate/migrate": "migrated\n"}}
+}
+
+func newTestPodStep(t *testing.T, f *fakePodLogsCore, waitFor ...string) *PodStep {
+	task := playbook.Task{Name: "Migrate", PodManifest: "migrate", WaitFor: waitFor}
+	step, err := NewPodStep(f, Owner{}, task, "default", podTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return step
+}
+
+func TestPodStepRunSucceeds(t *testing.T) {
+	f := newFakePodCore(v1.PodRunning, v1.PodSucceeded)
+	step := newTestPodStep(t, f, playbook.WaitForSuccess)
+
+	result, err := step.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, PodResult{Name: "migrate", Phase: v1.PodSucceeded, Logs: "migrated\n"}, result)
+
+	actions := f.Actions()
+	if assert.Len(t, actions, 3) {
+		assert.Equal(t, "delete", actions[0].GetVerb())
+		assert.Equal(t, "create", actions[1].GetVerb())
+		assert.Equal(t, "watch", actions[2].GetVerb())
+	}
+	created := actions[1].(core.CreateAction).GetObject().(*v1.Pod)
+	assert.Equal(t, v1.RestartPolicyNever, created.Spec.RestartPolicy)
+}
+
+func TestPodStepRunKeepsLogsError(t *testing.T) {
+	f := newFakePodCore(v1.PodSucceeded)
+	f.logsErr = fmt.Errorf("kubelet unreachable")
+	step := newTestPodStep(t, f, playbook.WaitForSuccess)
+
+	result, err := step.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, v1.PodSucceeded, result.Phase)
+	assert.Equal(t, "kubelet unreachable", result.LogsError)
+}
+
+func TestPodStepRunWrongPhase(t *testing.T) {
+	f := newFakePodCore(v1.PodRunning, v1.PodFailed)
+	step := newTestPodStep(t, f)
+
+	result, err := step.Run()
+	assert.Equal(t, PodPhaseError{Pod: "migrate", Phase: v1.PodFailed, WaitFor: []string{"success"}}, err)
+	assert.Equal(t, "Pod migrate ended in phase Failed, expected success", err.Error())
+	assert.Equal(t, v1.PodFailed, result.Phase)
+	assert.Equal(t, "migrated\n", result.Logs)
+}
+
+func TestPodStepRunWaitForFailure(t *testing.T) {
+	f := newFakePodCore(v1.PodFailed)
+	step := newTestPodStep(t, f, playbook.WaitForFailure)
+
+	result, err := step.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, v1.PodFailed, result.Phase)
+}
+
+func TestPodStepRunWaitForRunning(t *testing.T) {
+	f := newFakePodCore(v1.PodRunning)
+	step := newTestPodStep(t, f, playbook.WaitForRunning)
+
+	result, err := step.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, v1.PodRunning, result.Phase)
+}
+
+func TestPodStepRunTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { PodTimeout = timeout }(PodTimeout)
+	PodTimeout = 10 * time.Millisecond
+
+	f := newFakePodCore()
+	step := newTestPodStep(t, f)
+
+	result, err := step.Run()
+	assert.Equal(t, PodTimeoutError{Pod: "migrate", Phase: v1.PodPending, Timeout: PodTimeout}, err)
+	assert.Equal(t, "migrated\n", result.Logs)
+}
+
+func TestPodStepReplacesTerminatingPod(t *testing.T) {
+	defer func(interval time.Duration) { podRetryInterval = interval }(podRetryInterval)
+	podRetryInterval = time.Millisecond
+
+	f := newFakePodCore(v1.PodSucceeded)
+	exists := true
+	f.PrependReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		if exists {
+			exists = false
+			return true, nil, errors.NewAlreadyExists(unversioned.GroupResource{Resource: "pods"}, "migrate")
+		}
+		return false, nil, nil
+	})
+	step := newTestPodStep(t, f)
+
+	_, err := step.Run()
+	assert.Nil(t, err)
+	creates := 0
+	for _, action := range f.Actions() {
+		if action.GetVerb() == "create" {
+			creates++
+		}
+	}
+	assert.Equal(t, 2, creates)
+}
+
+func TestNewPodStepRequiresPod(t *testing.T) {
+	task := playbook.Task{Name: "Migrate", PodManifest: "test"}
+	_, err := NewPodStep(&fake.FakeCore{Fake: &core.Fake{}}, Owner{}, task, "default", mtemplate)
+	assert.EqualError(t, err, "Pod manifest test is a ReplicationController, not a Pod")
+}
+
+func TestDeployPodManifestTask(t *testing.T) {
+	m, _ := manifest.New("migrate", podTemplate)
+	f := newFakePodCore(v1.PodFailed)
+	d := &Deployment{
+		Client: f,
+		Playbook: playbook.Playbook{
+			ID:    "test",
+			Name:  "Test deployment",
+			Tasks: []playbook.Task{{Name: "Migrate", PodManifest: "migrate"}},
+		},
+		Manifests:  map[string]*manifest.Manifest{"migrate": m},
+		Store:      store.NewMemory(),
+		InstanceID: "pr-1",
+		Revision:   1,
+	}
+
+	result, err := d.Deploy()
+	if assert.IsType(t, TaskError{}, err) {
+		assert.Equal(t, "Migrate", err.(TaskError).Task)
+		assert.IsType(t, PodPhaseError{}, err.(TaskError).Err)
+	}
+	if assert.Len(t, result.Tasks, 1) && assert.NotNil(t, result.Tasks[0].Pod) {
+		assert.Equal(t, v1.PodFailed, result.Tasks[0].Pod.Phase)
+		assert.Equal(t, "migrated\n", result.Tasks[0].Pod.Logs)
+	}
+	rev, err := GetRevision(d.Store, "test", "pr-1", 1)
+	assert.Nil(t, err)
+	if assert.Len(t, rev.Tasks, 1) {
+		assert.Equal(t, result.Tasks[0].Pod, rev.Tasks[0].Pod)
+	}
+}
@@ -0,0 +1,201 @@
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/namely/broadway/store"
+)
+
+// queuePath is where pending deploy requests are kept in the store
+const queuePath = "/broadway/queue/deploy"
+
+// claimsPath is where the instances being deployed are claimed, by playbook
+// and instance ID, so that no two servers deploy an instance at once
+const claimsPath = "/broadway/queue/active"
+
+// ClaimTimeout is how long a claimed instance may go without its claim being
+// renewed. An instance whose claim is older, as after its server crashed, is
+// deployed again by the next server to claim it. Timeouts below
+// MinClaimTimeout use MinClaimTimeout.
+var ClaimTimeout = time.Minute
+
+// MinClaimTimeout is the shortest claim timeout, which claims are renewed
+// well within
+const MinClaimTimeout = time.Second
+
+// Request asks for one instance to be deployed
+type Request struct {
+	PlaybookID string `json:"playbook_id"`
+	InstanceID string `json:"instance_id"`
+	// User is who asked for the deployment
+	User string `json:"user,omitempty"`
+	// Rollback asks for this revision of the instance to be applied again
+	// instead of deploying its playbook
+	Rollback int `json:"rollback,omitempty"`
+
+	key string
+	// claim is the value of the claim on the request's instance, once the
+	// request was claimed
+	claim string
+}
+
+// Queue is a first-in first-out list of deploy requests persisted in a
+// store.Store, so that requests survive a restart. Requests pushed through a
+// Queue wake up the workers of a Pool sharing it; other Pools pick them up on
+// their next poll.
+type Queue struct {
+	store  store.Store
+	notify chan struct{}
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewQueue returns a Queue backed by store s
+func NewQueue(s store.Store) *Queue {
+	return &Queue{
+		store:  s,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Push appends a deploy request for an instance to the queue
+func (q *Queue) Push(playbookID, instanceID string) error {
+	return q.PushRequest(Request{PlaybookID: playbookID, InstanceID: instanceID})
+}
+
+// PushRequest appends a request to the queue
+func (q *Queue) PushRequest(r Request) error {
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.seq = (q.seq + 1) % 1000000
+	key := fmt.Sprintf("%020d%06d", time.Now().UnixNano(), q.seq)
+	q.mu.Unlock()
+
+	if err := q.store.SetValue(queuePath+"/"+key, string(encoded)); err != nil {
+		return err
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pending returns the queued requests, oldest first
+func (q *Queue) Pending() []Request {
+	values := q.store.Values(queuePath)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	requests := make([]Request, 0, len(keys))
+	for _, key := range keys {
+		var r Request
+		if err := json.Unmarshal([]byte(values[key]), &r); err != nil {
+			log.Printf("Dropping malformed deploy request %s: %s\n", key, err)
+			if err := q.store.Delete(queuePath + "/" + key); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		r.key = key
+		requests = append(requests, r)
+	}
+	return requests
+}
+
+// Claim claims the instance of a request returned by Pending, and reports
+// whether no other worker or server holds a claim on it. A request stays in
+// the queue until it is removed, so the instance of a request whose claim
+// is not renewed within ClaimTimeout can be claimed again.
+func (q *Queue) Claim(r *Request) bool {
+	path := claimKey(*r)
+	claim := newClaim(*r)
+	err := q.store.CreateValue(path, claim)
+	if err == store.ErrExists {
+		current := q.store.Value(path)
+		if !claimExpired(current) {
+			return false
+		}
+		// Of the servers taking over an abandoned claim, only the first
+		// swaps the value it read
+		err = q.store.SwapValue(path, current, claim)
+	}
+	if err != nil {
+		if err != store.ErrExists && err != store.ErrChanged {
+			log.Printf("Failed to claim %s/%s: %s\n", r.PlaybookID, r.InstanceID, err)
+		}
+		return false
+	}
+	// A request another server finished while this one read the queue is not
+	// deployed again
+	if q.store.Value(queuePath+"/"+r.key) == "" {
+		if err := q.store.DeleteValue(path, claim); err != nil {
+			log.Printf("Failed to release %s/%s: %s\n", r.PlaybookID, r.InstanceID, err)
+		}
+		return false
+	}
+	r.claim = claim
+	return true
+}
+
+// Renew keeps the claim on the instance of a claimed request from timing
+// out. It returns store.ErrChanged if another server took the claim over.
+func (q *Queue) Renew(r *Request) error {
+	claim := newClaim(*r)
+	if err := q.store.SwapValue(claimKey(*r), r.claim, claim); err != nil {
+		return err
+	}
+	r.claim = claim
+	return nil
+}
+
+// Remove deletes a request returned by Pending from the queue, and releases
+// the claim on its instance if the request holds it
+func (q *Queue) Remove(r Request) error {
+	if err := q.store.Delete(queuePath + "/" + r.key); err != nil {
+		return err
+	}
+	if r.claim == "" {
+		return nil
+	}
+	return q.store.DeleteValue(claimKey(r), r.claim)
+}
+
+func claimKey(r Request) string {
+	return claimsPath + "/" + r.PlaybookID + "/" + r.InstanceID
+}
+
+// newClaim returns a claim on the instance of r: when it was made or last
+// renewed, and by which request
+func newClaim(r Request) string {
+	return time.Now().UTC().Format(time.RFC3339Nano) + " " + r.key
+}
+
+// claimExpired reports whether claim was last renewed more than the claim
+// timeout ago
+func claimExpired(claim string) bool {
+	renewed, err := time.Parse(time.RFC3339Nano, strings.SplitN(claim, " ", 2)[0])
+	return err != nil || time.Since(renewed) >= claimTimeout()
+}
+
+// claimTimeout returns ClaimTimeout, or MinClaimTimeout if it is shorter
+func claimTimeout() time.Duration {
+	if ClaimTimeout < MinClaimTimeout {
+		return MinClaimTimeout
+	}
+	return ClaimTimeout
+}
@@ -0,0 +1,95 @@
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/namely/broadway/store"
+)
+
+func TestQueuePendingOrder(t *testing.T) {
+	q := NewQueue(store.NewMemory())
+	assert.Nil(t, q.Push("web", "first"))
+	assert.Nil(t, q.Push("web", "second"))
+	assert.Nil(t, q.Push("api", "third"))
+
+	pending := q.Pending()
+	if assert.Len(t, pending, 3) {
+		assert.Equal(t, "first", pending[0].InstanceID)
+		assert.Equal(t, "second", pending[1].InstanceID)
+		assert.Equal(t, "api", pending[2].PlaybookID)
+	}
+
+	assert.Nil(t, q.Remove(pending[0]))
+	pending = q.Pending()
+	if assert.Len(t, pending, 2) {
+		assert.Equal(t, "second", pending[0].InstanceID)
+	}
+}
+
+func TestQueueIsPersistent(t *testing.T) {
+	s := store.NewMemory()
+	assert.Nil(t, NewQueue(s).Push("web", "first"))
+
+	pending := NewQueue(s).Pending()
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, Request{PlaybookID: "web", InstanceID: "first", key: pending[0].key}, pending[0])
+	}
+}
+
+func TestQueueClaims(t *testing.T) {
+	s := store.NewMemory()
+	q := NewQueue(s)
+	assert.Nil(t, q.Push("web", "first"))
+	assert.Nil(t, q.Push("web", "first"))
+	pending := q.Pending()
+	r, next := pending[0], pending[1]
+
+	assert.True(t, q.Claim(&r))
+	// No other request for the instance is claimed, here or on another server
+	assert.False(t, q.Claim(&next))
+	assert.False(t, NewQueue(s).Claim(&next))
+	assert.Len(t, q.Pending(), 2)
+
+	claim := r.claim
+	assert.Nil(t, q.Renew(&r))
+	assert.NotEqual(t, claim, r.claim)
+
+	assert.Nil(t, q.Remove(r))
+	assert.Len(t, q.Pending(), 1)
+	assert.Empty(t, s.Values(claimsPath))
+	assert.True(t, q.Claim(&next))
+}
+
+func TestQueueTakesOverAbandonedClaims(t *testing.T) {
+	s := store.NewMemory()
+	q := NewQueue(s)
+	assert.Nil(t, q.Push("web", "first"))
+	r := q.Pending()[0]
+
+	// The server that claimed the instance stopped renewing its claim
+	abandoned := time.Now().Add(-2*ClaimTimeout).UTC().Format(time.RFC3339Nano) + " " + r.key
+	assert.Nil(t, s.SetValue(claimKey(r), abandoned))
+
+	taken := r
+	assert.True(t, NewQueue(s).Claim(&taken))
+	assert.False(t, q.Claim(&r))
+	// The server that lost the claim cannot renew or release it
+	r.claim = abandoned
+	assert.Equal(t, store.ErrChanged, q.Renew(&r))
+	assert.Equal(t, taken.claim, s.Value(claimKey(r)))
+}
+
+func TestQueueDoesNotClaimRemovedRequests(t *testing.T) {
+	s := store.NewMemory()
+	q := NewQueue(s)
+	assert.Nil(t, q.Push("web", "first"))
+	r := q.Pending()[0]
+
+	// Another server finished the request after this one read the queue
+	assert.Nil(t, NewQueue(s).Remove(r))
+	assert.False(t, q.Claim(&r))
+	assert.Empty(t, s.Values(claimsPath))
+}
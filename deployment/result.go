@@ -0,0 +1,38 @@
+package deployment
+
+import "fmt"
+
+// TaskError reports the playbook task a deployment failed in
+type TaskError struct {
+	Task string
+	Err  error
+}
+
+func (e TaskError) Error() string {
+	return fmt.Sprintf("Task %s failed: %s", e.Task, e.Err)
+}
+
+// ObjectResult reports what applying one manifest object did
+type ObjectResult struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Change Change `json:"change"`
+}
+
+// TaskResult collects the results of one playbook task
+type TaskResult struct {
+	Name    string         `json:"name"`
+	Objects []ObjectResult `json:"objects,omitempty"`
+	// Skipped is set when the task's when condition was false
+	Skipped bool `json:"skipped,omitempty"`
+	// Pod reports the run of the task's pod manifest, if it has one
+	Pod *PodResult `json:"pod,omitempty"`
+	// Attempts lists every attempt at deploying a task that has retries
+	Attempts []Attempt `json:"attempts,omitempty"`
+}
+
+// Result reports the outcome of a deployment, task by task. A failed
+// deployment returns the results up to and including the failing task.
+type Result struct {
+	Tasks []TaskResult `json:"tasks"`
+}
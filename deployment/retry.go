@@ -0,0 +1,99 @@
+package deployment
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+
+	"github.com/namely/broadway/playbook"
+)
+
+// DefaultRetryDelay is how long a task with retries waits before its second
+// attempt, unless the task sets a retry_delay
+var DefaultRetryDelay = time.Second
+
+// MaxRetryDelay bounds how long a task waits between attempts
+var MaxRetryDelay = time.Minute
+
+// Attempt reports one attempt at deploying a task
+type Attempt struct {
+	Number int    `json:"number"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newAttempt(number int, err error) Attempt {
+	attempt := Attempt{Number: number}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	return attempt
+}
+
+// IsRetryable reports whether a task that failed with err may succeed when
+// deployed again: the API server reported a conflict, a timeout or an error
+// of its own, could not be reached or dropped the connection, or the task or
+// its pods did not finish in time. Errors in what the task applies, such as
+// invalid objects, are not retryable.
+func IsRetryable(err error) bool {
+	switch err.(type) {
+	case RolloutTimeoutError, PodTimeoutError, TaskTimeoutError:
+		return true
+	}
+	if status, ok := err.(errors.APIStatus); ok {
+		s := status.Status()
+		switch s.Reason {
+		case unversioned.StatusReasonConflict, unversioned.StatusReasonServerTimeout, unversioned.StatusReasonTimeout:
+			return true
+		}
+		return s.Code == http.StatusTooManyRequests || s.Code >= http.StatusInternalServerError
+	}
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if netErr, ok := err.(net.Error); ok && (netErr.Timeout() || netErr.Temporary()) {
+		return true
+	}
+	return isConnectionError(err)
+}
+
+// isConnectionError reports whether err is a connection to the API server
+// that was refused, reset or closed before it answered
+func isConnectionError(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	switch err {
+	case syscall.ECONNREFUSED, syscall.ECONNRESET, io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns how long the task waits after the given failed attempt.
+// The delay doubles with each attempt, up to MaxRetryDelay.
+func retryDelay(task playbook.Task, attempt int) time.Duration {
+	delay := task.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	for n := 1; n < attempt; n++ {
+		delay *= 2
+		if delay >= MaxRetryDelay {
+			return MaxRetryDelay
+		}
+	}
+	if delay > MaxRetryDelay {
+		return MaxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,150 @@
+package deployment
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/client/testing/core"
+	"k8s.io/kubernetes/pkg/client/typed/generated/core/v1/fake"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/util/validation/field"
+
+	"github.com/namely/broadway/playbook"
+)
+
+var services = unversioned.GroupResource{Resource: "services"}
+
+func TestIsRetryable(t *testing.T) {
+	testcases := []struct {
+		scenario  string
+		err       error
+		retryable bool
+	}{
+		{"Conflict", errors.NewConflict(services, "web", fmt.Errorf("changed")), true},
+		{"Server timeout", errors.NewServerTimeout(services, "create", 1), true},
+		{"Too many requests", errors.NewGenericServerResponse(429, "POST", services, "web", "", 1, false), true},
+		{"Internal error", errors.NewInternalError(fmt.Errorf("etcd down")), true},
+		{"Service unavailable", errors.NewServiceUnavailable("restarting"), true},
+		{"Rollout timeout", RolloutTimeoutError{Name: "web"}, true},
+		{"Pod timeout", PodTimeoutError{Pod: "migrate"}, true},
+		{"Invalid", errors.NewInvalid(unversioned.GroupKind{Kind: "Service"}, "web", field.ErrorList{}), false},
+		{"Bad request", errors.NewBadRequest("bad"), false},
+		{"Already exists", errors.NewAlreadyExists(services, "web"), false},
+		{"Unsupported kind", UnsupportedKindError{Kind: "Node"}, false},
+		{"Manifest", ManifestError{Manifest: "bad", Err: UnsupportedKindError{Kind: "Node"}}, false},
+		{"Pod phase", PodPhaseError{Pod: "migrate"}, false},
+		{"Task timeout", TaskTimeoutError{Task: "Web"}, true},
+		{"API timeout", &url.Error{Op: "Get", URL: "/api", Err: APITimeoutError{}}, true},
+		{"Connection refused", &url.Error{Op: "Get", URL: "/api", Err: &net.OpError{Op: "dial", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}}, true},
+		{"Connection reset", &net.OpError{Op: "read", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}, true},
+		{"Connection closed", &url.Error{Op: "Get", URL: "/api", Err: io.EOF}, true},
+		{"Unknown host", &url.Error{Op: "Get", URL: "/api", Err: &net.DNSError{Err: "no such host", Name: "k8s"}}, false},
+	}
+	for _, testcase := range testcases {
+		assert.Equal(t, testcase.retryable, IsRetryable(testcase.err), testcase.scenario)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	defer func(delay time.Duration) { MaxRetryDelay = delay }(MaxRetryDelay)
+	MaxRetryDelay = 30 * time.Second
+
+	task := playbook.Task{RetryDelay: 5 * time.Second}
+	assert.Equal(t, 5*time.Second, retryDelay(task, 1))
+	assert.Equal(t, 10*time.Second, retryDelay(task, 2))
+	assert.Equal(t, 20*time.Second, retryDelay(task, 3))
+	assert.Equal(t, 30*time.Second, retryDelay(task, 4))
+	assert.Equal(t, 30*time.Second, retryDelay(task, 100))
+	assert.Equal(t, DefaultRetryDelay, retryDelay(playbook.Task{}, 1))
+}
+
+// failingCreates makes a fake client fail its first creates of services with
+// err
+func failingCreates(f *fake.FakeCore, failures int, err error) {
+	f.AddReactor("create", "services", func(action core.Action) (bool, runtime.Object, error) {
+		if failures == 0 {
+			return false, nil, nil
+		}
+		failures--
+		return true, nil, err
+	})
+}
+
+func TestDeployRetriesRetryableErrors(t *testing.T) {
+	conflict := errors.NewConflict(services, "web", fmt.Errorf("changed"))
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+	failingCreates(f, 2, conflict)
+	d := newGraphDeployment(f, 0, playbook.Task{Name: "Web", Manifests: []string{"web"}, Retries: 3, RetryDelay: time.Millisecond})
+
+	result, err := d.Deploy()
+	assert.Nil(t, err)
+	assert.Equal(t, []Attempt{
+		{Number: 1, Error: conflict.Error()},
+		{Number: 2, Error: conflict.Error()},
+		{Number: 3},
+	}, result.Tasks[0].Attempts)
+	assert.Len(t, result.Tasks[0].Objects, 1)
+}
+
+func TestDeployGivesUpAfterRetries(t *testing.T) {
+	unavailable := errors.NewServiceUnavailable("restarting")
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+	failingCreates(f, 5, unavailable)
+	d := newGraphDeployment(f, 0, playbook.Task{Name: "Web", Manifests: []string{"web"}, Retries: 1, RetryDelay: time.Millisecond})
+
+	result, err := d.Deploy()
+	assert.Equal(t, TaskError{Task: "Web", Err: unavailable}, err)
+	assert.Equal(t, []Attempt{
+		{Number: 1, Error: unavailable.Error()},
+		{Number: 2, Error: unavailable.Error()},
+	}, result.Tasks[0].Attempts)
+}
+
+func TestDeployDoesNotRetryInvalidObjects(t *testing.T) {
+	invalid := errors.NewInvalid(unversioned.GroupKind{Kind: "Service"}, "web", field.ErrorList{})
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+	failingCreates(f, 1, invalid)
+	d := newGraphDeployment(f, 0, playbook.Task{Name: "Web", Manifests: []string{"web"}, Retries: 3, RetryDelay: time.Millisecond})
+
+	result, err := d.Deploy()
+	assert.Equal(t, TaskError{Task: "Web", Err: invalid}, err)
+	assert.Equal(t, []Attempt{{Number: 1, Error: invalid.Error()}}, result.Tasks[0].Attempts)
+}
+
+func TestDeployEndsAttemptsAtTheTaskTimeout(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+	d := newGraphDeployment(slowServices{f, &concurrentCreates{}}, 0,
+		playbook.Task{Name: "Services", Manifests: []string{"web", "api"}, Timeout: 10 * time.Millisecond})
+
+	result, err := d.Deploy()
+	assert.Equal(t, TaskError{Task: "Services", Err: TaskTimeoutError{Task: "Services", Timeout: 10 * time.Millisecond}}, err)
+	assert.Len(t, result.Tasks[0].Objects, 1)
+}
+
+func TestDeployStopsWaitingToRetry(t *testing.T) {
+	conflict := errors.NewConflict(services, "web", fmt.Errorf("changed"))
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+	failingCreates(f, 5, conflict)
+	d := newGraphDeployment(f, 0, playbook.Task{Name: "Web", Manifests: []string{"web"}, Retries: 3, RetryDelay: time.Hour})
+	stop := make(chan struct{})
+	close(stop)
+	d.Stop = stop
+
+	result, err := d.Deploy()
+	assert.Equal(t, TaskError{Task: "Web", Err: conflict}, err)
+	assert.Equal(t, []Attempt{{Number: 1, Error: conflict.Error()}}, result.Tasks[0].Attempts)
+}
@@ -0,0 +1,298 @@
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/meta"
+	"k8s.io/kubernetes/pkg/runtime"
+
+	"github.com/namely/broadway/playbook"
+	"github.com/namely/broadway/store"
+)
+
+const revisionsPath = "/broadway/revisions/"
+
+// Outcomes of a revision and of its tasks
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomeSkipped   = "skipped"
+)
+
+// Revision records one deployment of an instance: who asked for it, when it
+// ran, the vars and rendered manifests it applied and how each task went
+type Revision struct {
+	Number   int               `json:"number"`
+	User     string            `json:"user,omitempty"`
+	Started  string            `json:"started"`
+	Finished string            `json:"finished"`
+	Outcome  string            `json:"outcome"`
+	Error    string            `json:"error,omitempty"`
+	Vars     map[string]string `json:"vars"`
+	Tasks    []RevisionTask    `json:"tasks"`
+	// PlaybookVersion is the uploaded playbook version deployed, if the
+	// playbook was uploaded
+	PlaybookVersion int `json:"playbook_version,omitempty"`
+	// RollbackOf is the revision a manual rollback applied again
+	RollbackOf int `json:"rollback_of,omitempty"`
+	// RolledBackTo is the revision restored after this one failed
+	RolledBackTo int `json:"rolled_back_to,omitempty"`
+}
+
+// RevisionTask records how one task of a revision went. Manifests holds the
+// manifests the task applied, as rendered, and Objects what applying each
+// object did. Pod manifests are not recorded, as their pods are not run again
+// on rollback, but Pod reports how the task's pod ran and its logs. A task
+// with retries lists each attempt at it.
+type RevisionTask struct {
+	Name      string         `json:"name"`
+	Outcome   string         `json:"outcome"`
+	Error     string         `json:"error,omitempty"`
+	Manifests []string       `json:"manifests,omitempty"`
+	Objects   []ObjectResult `json:"objects,omitempty"`
+	Pod       *PodResult     `json:"pod,omitempty"`
+	Attempts  []Attempt      `json:"attempts,omitempty"`
+}
+
+// RevisionNotFoundError is returned when an instance has no revision with a
+// given number
+type RevisionNotFoundError struct {
+	Number int
+}
+
+func (e RevisionNotFoundError) Error() string {
+	return fmt.Sprintf("Revision %d not found", e.Number)
+}
+
+func revisionsKey(playbookID, instanceID string) string {
+	return revisionsPath + playbookID + "/" + instanceID
+}
+
+// SaveRevision stores a revision of an instance
+func SaveRevision(s store.Store, playbookID, instanceID string, rev *Revision) error {
+	encoded, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+	key := revisionsKey(playbookID, instanceID) + "/" + strconv.Itoa(rev.Number)
+	return s.SetValue(key, string(encoded))
+}
+
+// GetRevision looks up a revision of an instance by number
+func GetRevision(s store.Store, playbookID, instanceID string, number int) (*Revision, error) {
+	v := s.Value(revisionsKey(playbookID, instanceID) + "/" + strconv.Itoa(number))
+	if v == "" {
+		return nil, RevisionNotFoundError{Number: number}
+	}
+	var rev Revision
+	if err := json.Unmarshal([]byte(v), &rev); err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// Revisions returns the revisions of an instance, oldest first
+func Revisions(s store.Store, playbookID, instanceID string) ([]Revision, error) {
+	revisions := []Revision{}
+	for _, v := range s.Values(revisionsKey(playbookID, instanceID)) {
+		var rev Revision
+		if err := json.Unmarshal([]byte(v), &rev); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	sort.Sort(revisionsByNumber(revisions))
+	return revisions, nil
+}
+
+// LastGoodRevision returns the latest successful revision of an instance
+// numbered below before, or nil if there is none
+func LastGoodRevision(s store.Store, playbookID, instanceID string, before int) (*Revision, error) {
+	revisions, err := Revisions(s, playbookID, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	for n := len(revisions) - 1; n >= 0; n-- {
+		if revisions[n].Number < before && revisions[n].Outcome == OutcomeSucceeded {
+			return &revisions[n], nil
+		}
+	}
+	return nil, nil
+}
+
+// NextRevision returns the number of the next revision of an instance last
+// deployed as revision last: one more than last or than the highest stored
+// revision, so that no revision is ever overwritten
+func NextRevision(s store.Store, playbookID, instanceID string, last int) (int, error) {
+	revisions, err := Revisions(s, playbookID, instanceID)
+	if err != nil {
+		return 0, err
+	}
+	if n := len(revisions); n > 0 && revisions[n-1].Number > last {
+		last = revisions[n-1].Number
+	}
+	return last + 1, nil
+}
+
+// DeleteRevisions forgets the revision history of an instance
+func DeleteRevisions(s store.Store, playbookID, instanceID string) error {
+	return s.Delete(revisionsKey(playbookID, instanceID))
+}
+
+type revisionsByNumber []Revision
+
+func (r revisionsByNumber) Len() int           { return len(r) }
+func (r revisionsByNumber) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r revisionsByNumber) Less(i, j int) bool { return r[i].Number < r[j].Number }
+
+func (d *Deployment) newRevision() *Revision {
+	return &Revision{
+		Number:  d.Revision,
+		User:    d.User,
+		Started: time.Now().UTC().Format(time.RFC3339),
+		Vars:    d.Variables,
+		Tasks:   []RevisionTask{},
+
+		PlaybookVersion: d.PlaybookVersion,
+	}
+}
+
+// addTask records the result of a task that applied manifests
+func (rev *Revision) addTask(result TaskResult, manifests []string, err error) {
+	task := RevisionTask{
+		Name:      result.Name,
+		Outcome:   OutcomeSucceeded,
+		Manifests: manifests,
+		Objects:   result.Objects,
+		Pod:       result.Pod,
+		Attempts:  result.Attempts,
+	}
+	if err != nil {
+		task.Outcome = OutcomeFailed
+		task.Error = err.Error()
+	}
+	rev.Tasks = append(rev.Tasks, task)
+}
+
+// record finishes rev with the outcome err and saves it, if the deployment
+// has a Store
+func (d *Deployment) record(rev *Revision, err error) {
+	if d.Store == nil {
+		return
+	}
+	rev.Finished = time.Now().UTC().Format(time.RFC3339)
+	rev.Outcome = OutcomeSucceeded
+	if err != nil {
+		rev.Outcome = OutcomeFailed
+		rev.Error = err.Error()
+	}
+	if err := SaveRevision(d.Store, d.Playbook.ID, d.InstanceID, rev); err != nil {
+		log.Printf("Failed to save revision %d of %s/%s: %s\n", rev.Number, d.Playbook.ID, d.InstanceID, err)
+	}
+}
+
+// Rollback applies the manifests of target again, recording a new revision.
+// Pod manifests are not run again.
+func (d *Deployment) Rollback(target *Revision) (*Result, error) {
+	d.Variables = target.Vars
+	d.PlaybookVersion = target.PlaybookVersion
+	rev := d.newRevision()
+	rev.RollbackOf = target.Number
+	result, err := d.restore(target, rev)
+	d.record(rev, err)
+	return result, err
+}
+
+// restore applies the manifests of target again, adding the tasks to rev
+func (d *Deployment) restore(target *Revision, rev *Revision) (*Result, error) {
+	result := &Result{}
+	for _, task := range target.Tasks {
+		if len(task.Manifests) == 0 {
+			result.Tasks = append(result.Tasks, TaskResult{Name: task.Name, Skipped: true})
+			rev.Tasks = append(rev.Tasks, RevisionTask{Name: task.Name, Outcome: OutcomeSkipped})
+			continue
+		}
+		taskResult, err := d.restoreTask(task)
+		result.Tasks = append(result.Tasks, taskResult)
+		rev.addTask(taskResult, task.Manifests, err)
+		if err != nil {
+			return result, TaskError{Task: task.Name, Err: err}
+		}
+	}
+	return result, nil
+}
+
+func (d *Deployment) restoreTask(task RevisionTask) (TaskResult, error) {
+	result := TaskResult{Name: task.Name}
+	for _, rendered := range task.Manifests {
+		step, err := NewDefaultStep(d.Client, d.owner(), playbook.Task{Name: task.Name}, d.namespace(), rendered)
+		if err != nil {
+			return result, err
+		}
+		objectResult, err := step.Deploy()
+		if err != nil {
+			return result, err
+		}
+		result.Objects = append(result.Objects, objectResult)
+	}
+	return result, nil
+}
+
+// removeLeftovers deletes the objects the failed revision applied that
+// target does not hold, so that a rollback to target leaves nothing of the
+// failed deployment running. Objects the failed revision applied unchanged
+// were there before it and are kept.
+func (d *Deployment) removeLeftovers(failed, target *Revision) error {
+	kept := map[string]bool{}
+	for _, task := range target.Tasks {
+		for _, rendered := range task.Manifests {
+			object, _, err := deserializer.Decode([]byte(rendered), &groupVersionKind, nil)
+			if err != nil {
+				return err
+			}
+			kept[kindOf(object)+"/"+nameOf(object)] = true
+		}
+	}
+	for _, task := range failed.Tasks {
+		for _, rendered := range task.Manifests {
+			object, _, err := deserializer.Decode([]byte(rendered), &groupVersionKind, nil)
+			if err != nil {
+				return err
+			}
+			if kept[kindOf(object)+"/"+nameOf(object)] {
+				continue
+			}
+			live, err := getObject(d.Client, d.namespace(), object)
+			if errors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if revisionOf(live) != failed.Number {
+				continue
+			}
+			if err := deleteObject(d.Client, d.namespace(), object); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("Failed to delete %s %s: %s", kindOf(object), nameOf(object), err)
+			}
+		}
+	}
+	return nil
+}
+
+// revisionOf returns the revision that last changed a live object, or 0 if
+// it carries no revision annotation
+func revisionOf(object runtime.Object) int {
+	m, err := meta.Accessor(object)
+	if err != nil {
+		return 0
+	}
+	revision, _ := strconv.Atoi(m.GetAnnotations()[RevisionAnnotation])
+	return revision
+}
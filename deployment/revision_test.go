@@ -0,0 +1,198 @@
+package deployment
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/client/testing/core"
+	"k8s.io/kubernetes/pkg/client/typed/generated/core/v1/fake"
+	"k8s.io/kubernetes/pkg/runtime"
+
+	"github.com/namely/broadway/manifest"
+	"github.com/namely/broadway/playbook"
+	"github.com/namely/broadway/store"
+)
+
+func TestRevisionStorage(t *testing.T) {
+	s := store.NewMemory()
+	_, err := GetRevision(s, "test", "pr-1", 1)
+	assert.Equal(t, RevisionNotFoundError{Number: 1}, err)
+
+	for _, rev := range []*Revision{
+		{Number: 10, Outcome: OutcomeFailed},
+		{Number: 2, Outcome: OutcomeSucceeded},
+		{Number: 9, Outcome: OutcomeSucceeded},
+	} {
+		assert.Nil(t, SaveRevision(s, "test", "pr-1", rev))
+	}
+	revisions, err := Revisions(s, "test", "pr-1")
+	assert.Nil(t, err)
+	if assert.Len(t, revisions, 3) {
+		assert.Equal(t, 2, revisions[0].Number)
+		assert.Equal(t, 9, revisions[1].Number)
+		assert.Equal(t, 10, revisions[2].Number)
+	}
+
+	good, err := LastGoodRevision(s, "test", "pr-1", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 9, good.Number)
+	good, err = LastGoodRevision(s, "test", "pr-1", 2)
+	assert.Nil(t, err)
+	assert.Nil(t, good)
+
+	next, err := NextRevision(s, "test", "pr-1", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, next)
+	next, err = NextRevision(s, "test", "pr-1", 12)
+	assert.Nil(t, err)
+	assert.Equal(t, 13, next)
+
+	assert.Nil(t, DeleteRevisions(s, "test", "pr-1"))
+	revisions, err = Revisions(s, "test", "pr-1")
+	assert.Nil(t, err)
+	assert.Empty(t, revisions)
+}
+
+func TestDeployRecordsRevision(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+	m, _ := manifest.New("test", mtemplate)
+	s := store.NewMemory()
+	d := &Deployment{
+		Client: f,
+		Playbook: playbook.Playbook{
+			ID: "test",
+			Tasks: []playbook.Task{
+				{Name: "Deploy", Manifests: []string{"test"}},
+				{Name: "Skipped", Manifests: []string{"test"}, When: "missing"},
+				{Name: "Broken", Manifests: []string{"missing"}},
+			},
+		},
+		Variables:  map[string]string{"version": "1"},
+		Manifests:  map[string]*manifest.Manifest{"test": m},
+		Store:      s,
+		InstanceID: "pr-1",
+		Revision:   7,
+		User:       "alice",
+	}
+
+	_, err := d.Deploy()
+	assert.NotNil(t, err)
+	rev, err := GetRevision(s, "test", "pr-1", 7)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", rev.User)
+	assert.Equal(t, OutcomeFailed, rev.Outcome)
+	assert.Equal(t, "Task Broken failed: Manifest missing not found", rev.Error)
+	assert.NotEmpty(t, rev.Started)
+	assert.NotEmpty(t, rev.Finished)
+	assert.Equal(t, map[string]string{"version": "1"}, rev.Vars)
+	assert.Equal(t, []RevisionTask{
+		{
+			Name:      "Deploy",
+			Outcome:   OutcomeSucceeded,
+			Manifests: []string{mtemplate},
+			Objects:   []ObjectResult{{Kind: "ReplicationController", Name: "test", Change: ChangeCreated}},
+		},
+		{Name: "Skipped", Outcome: OutcomeSkipped},
+		{Name: "Broken", Outcome: OutcomeFailed, Error: "Manifest missing not found"},
+	}, rev.Tasks)
+}
+
+func TestRollback(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+	s := store.NewMemory()
+	d := &Deployment{
+		Client:     f,
+		Playbook:   playbook.Playbook{ID: "test"},
+		Namespace:  "broadway-test-pr-1",
+		Store:      s,
+		InstanceID: "pr-1",
+		Revision:   3,
+	}
+
+	result, err := d.Rollback(&Revision{
+		Number:          1,
+		Vars:            map[string]string{"version": "1"},
+		PlaybookVersion: 2,
+		Tasks: []RevisionTask{
+			{Name: "Deploy", Outcome: OutcomeSucceeded, Manifests: []string{mtemplate}},
+			{Name: "Migrate", Outcome: OutcomeSucceeded},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []TaskResult{
+		{
+			Name:    "Deploy",
+			Objects: []ObjectResult{{Kind: "ReplicationController", Name: "test", Change: ChangeCreated}},
+		},
+		{Name: "Migrate", Skipped: true},
+	}, result.Tasks)
+	actions := f.Actions()
+	if assert.Len(t, actions, 2) {
+		assert.Equal(t, "create", actions[1].GetVerb())
+		assert.Equal(t, "broadway-test-pr-1", actions[1].GetNamespace())
+	}
+
+	rev, err := GetRevision(s, "test", "pr-1", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, rev.RollbackOf)
+	assert.Equal(t, OutcomeSucceeded, rev.Outcome)
+	assert.Equal(t, map[string]string{"version": "1"}, rev.Vars)
+	assert.Equal(t, 2, rev.PlaybookVersion)
+}
+
+func TestRollbackFailure(t *testing.T) {
+	d := &Deployment{Client: &fake.FakeCore{Fake: &core.Fake{}}}
+
+	_, err := d.Rollback(&Revision{
+		Tasks: []RevisionTask{{Name: "Break", Manifests: []string{"apiVersion: v1\nkind: Node\nmetadata:\n  name: test\n"}}},
+	})
+	assert.Equal(t, TaskError{Task: "Break", Err: UnsupportedKindError{Kind: "Node"}}, err)
+}
+
+func TestRemoveLeftovers(t *testing.T) {
+	// The live objects and the revisions that last changed them
+	live := map[string]runtime.Object{
+		"replicationcontrollers/test": &v1.ReplicationController{ObjectMeta: v1.ObjectMeta{
+			Name: "test", Annotations: map[string]string{RevisionAnnotation: "5"},
+		}},
+		"services/web": &v1.Service{ObjectMeta: v1.ObjectMeta{
+			Name: "web", Annotations: map[string]string{RevisionAnnotation: "5"},
+		}},
+		"services/api": &v1.Service{ObjectMeta: v1.ObjectMeta{
+			Name: "api", Annotations: map[string]string{RevisionAnnotation: "3"},
+		}},
+	}
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", func(action core.Action) (bool, runtime.Object, error) {
+		if object, ok := live[action.GetResource()+"/"+action.(core.GetAction).GetName()]; ok {
+			return true, object, nil
+		}
+		return notFoundReaction(action)
+	})
+	d := &Deployment{Client: f, Playbook: playbook.Playbook{ID: "test"}, InstanceID: "pr-1"}
+
+	api := strings.Replace(serviceManifest, "name: web", "name: api", 1)
+	secret := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: gone\n"
+	failed := &Revision{Number: 5, Tasks: []RevisionTask{
+		{Name: "Deploy", Manifests: []string{mtemplate, serviceManifest, api, secret}},
+	}}
+	target := &Revision{Number: 4, Tasks: []RevisionTask{
+		{Name: "Deploy", Manifests: []string{serviceManifest}},
+	}}
+	assert.Nil(t, d.removeLeftovers(failed, target))
+
+	var deleted []string
+	for _, action := range f.Actions() {
+		switch action.GetVerb() {
+		case "delete":
+			deleted = append(deleted, action.GetResource()+"/"+action.(core.DeleteAction).GetName())
+		case "delete-collection":
+			deleted = append(deleted, action.GetResource())
+		}
+	}
+	assert.Equal(t, []string{"replicationcontrollers/test", "pods"}, deleted)
+}
@@ -0,0 +1,192 @@
+package deployment
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/v1"
+	coreclient "k8s.io/kubernetes/pkg/client/typed/generated/core/v1"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/watch"
+
+	"github.com/namely/broadway/broadway"
+)
+
+// RolloutTimeout is how long a task with wait_ready waits for the pods of a
+// replication controller to become ready, unless the task sets a timeout
+var RolloutTimeout = 5 * time.Minute
+
+// RolloutTimeoutError is returned when the pods of a replication controller
+// are not ready in time. Pods describes the pods that were not ready.
+type RolloutTimeoutError struct {
+	Name    string
+	Ready   int32
+	Desired int32
+	Timeout time.Duration
+	Pods    []broadway.PodStatus
+}
+
+func (e RolloutTimeoutError) Error() string {
+	msg := fmt.Sprintf("ReplicationController %s has %d of %d pods ready after %s", e.Name, e.Ready, e.Desired, e.Timeout)
+	var problems []string
+	for _, pod := range e.Pods {
+		for _, c := range pod.Containers {
+			if c.Ready {
+				continue
+			}
+			problem := fmt.Sprintf("%s/%s %s", pod.Name, c.Name, c.State)
+			if c.LastTermination != "" {
+				problem += fmt.Sprintf(" (last %s)", c.LastTermination)
+			}
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		msg += ": " + strings.Join(problems, ", ")
+	}
+	return msg
+}
+
+// waitForRollout watches the pods selected by rc until as many are ready as
+// rc asks for, or timeout passes. A replication controller does not replace
+// its running pods when its template changes, so the pods counted after an
+// update may all run the previous template.
+func waitForRollout(client coreclient.CoreInterface, namespace string, rc *v1.ReplicationController, timeout time.Duration) error {
+	desired := int32(1)
+	if rc.Spec.Replicas != nil {
+		desired = *rc.Spec.Replicas
+	}
+	selector := rc.Spec.Selector
+	if len(selector) == 0 && rc.Spec.Template != nil {
+		selector = rc.Spec.Template.Labels
+	}
+	options := api.ListOptions{LabelSelector: labels.SelectorFromSet(selector)}
+
+	pods := map[string]*v1.Pod{}
+	list, err := client.Pods(namespace).List(options)
+	if err != nil {
+		return err
+	}
+	if list != nil {
+		for n := range list.Items {
+			pods[list.Items[n].Name] = &list.Items[n]
+		}
+		options.ResourceVersion = list.ResourceVersion
+	}
+	if readyPods(pods) >= desired {
+		return nil
+	}
+
+	w, err := client.Pods(namespace).Watch(options)
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	expired := time.After(timeout)
+	for {
+		select {
+		case <-expired:
+			return RolloutTimeoutError{
+				Name:    rc.Name,
+				Ready:   readyPods(pods),
+				Desired: desired,
+				Timeout: timeout,
+				Pods:    unreadyPodStatuses(pods),
+			}
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return fmt.Errorf("Watch of pods for ReplicationController %s closed", rc.Name)
+			}
+			if event.Type == watch.Error {
+				return errors.FromObject(event.Object)
+			}
+			pod, ok := event.Object.(*v1.Pod)
+			if !ok {
+				continue
+			}
+			if event.Type == watch.Deleted {
+				delete(pods, pod.Name)
+			} else {
+				pods[pod.Name] = pod
+			}
+			if readyPods(pods) >= desired {
+				return nil
+			}
+		}
+	}
+}
+
+func readyPods(pods map[string]*v1.Pod) int32 {
+	var ready int32
+	for _, pod := range pods {
+		if isPodReady(pod) {
+			ready++
+		}
+	}
+	return ready
+}
+
+func isPodReady(pod *v1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != v1.PodRunning {
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// unreadyPodStatuses describes the pods that are not ready, ordered by name
+func unreadyPodStatuses(pods map[string]*v1.Pod) []broadway.PodStatus {
+	var statuses []broadway.PodStatus
+	for _, pod := range pods {
+		if isPodReady(pod) {
+			continue
+		}
+		status := broadway.PodStatus{Name: pod.Name, Phase: string(pod.Status.Phase)}
+		for _, c := range pod.Status.ContainerStatuses {
+			status.Containers = append(status.Containers, broadway.ContainerStatus{
+				Name:            c.Name,
+				Ready:           c.Ready,
+				RestartCount:    c.RestartCount,
+				State:           describeContainerState(c.State),
+				LastTermination: describeTermination(c.LastTerminationState.Terminated),
+			})
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Sort(podStatusesByName(statuses))
+	return statuses
+}
+
+func describeContainerState(state v1.ContainerState) string {
+	switch {
+	case state.Waiting != nil:
+		return "waiting: " + state.Waiting.Reason
+	case state.Terminated != nil:
+		return describeTermination(state.Terminated)
+	case state.Running != nil:
+		return "running"
+	}
+	return "unknown"
+}
+
+func describeTermination(t *v1.ContainerStateTerminated) string {
+	if t == nil {
+		return ""
+	}
+	return fmt.Sprintf("terminated: %s, exit code %d", t.Reason, t.ExitCode)
+}
+
+type podStatusesByName []broadway.PodStatus
+
+func (s podStatusesByName) Len() int           { return len(s) }
+func (s podStatusesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s podStatusesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
@@ -0,0 +1,146 @@
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/client/testing/core"
+	"k8s.io/kubernetes/pkg/client/typed/generated/core/v1/fake"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+
+	"github.com/namely/broadway/broadway"
+	"github.com/namely/broadway/playbook"
+)
+
+func readyPod(name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: v1.ObjectMeta{Name: name, Labels: map[string]string{"name": "redis"}},
+		Status: v1.PodStatus{
+			Phase:      v1.PodRunning,
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+		},
+	}
+}
+
+func crashingPod(name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: v1.ObjectMeta{Name: name, Labels: map[string]string{"name": "redis"}},
+		Status: v1.PodStatus{
+			Phase:      v1.PodRunning,
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionFalse}},
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:         "redis",
+				RestartCount: 3,
+				State: v1.ContainerState{
+					Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+				},
+				LastTerminationState: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{Reason: "Error", ExitCode: 1},
+				},
+			}},
+		},
+	}
+}
+
+// newFakeRolloutCore returns a fake client listing listed pods and then
+// watching the watched ones being added
+func newFakeRolloutCore(listed []v1.Pod, watched ...*v1.Pod) *fake.FakeCore {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &v1.PodList{Items: listed}, nil
+	})
+	f.AddWatchReactor("pods", func(action core.Action) (bool, watch.Interface, error) {
+		w := watch.NewFake()
+		go func() {
+			for _, pod := range watched {
+				w.Add(pod)
+			}
+		}()
+		return true, w, nil
+	})
+	return f
+}
+
+func testRC(replicas int32) *v1.ReplicationController {
+	return &v1.ReplicationController{
+		ObjectMeta: v1.ObjectMeta{Name: "test"},
+		Spec: v1.ReplicationControllerSpec{
+			Replicas: &replicas,
+			Selector: map[string]string{"name": "redis"},
+		},
+	}
+}
+
+func TestWaitForRolloutAlreadyReady(t *testing.T) {
+	f := newFakeRolloutCore([]v1.Pod{*readyPod("a"), *readyPod("b")})
+
+	err := waitForRollout(f, "default", testRC(2), time.Second)
+	assert.Nil(t, err)
+	actions := f.Actions()
+	if assert.Len(t, actions, 1) {
+		assert.Equal(t, "list", actions[0].GetVerb())
+		assert.Equal(t, "name=redis", actions[0].(core.ListAction).GetListRestrictions().Labels.String())
+	}
+}
+
+func TestWaitForRolloutWatchesPods(t *testing.T) {
+	f := newFakeRolloutCore([]v1.Pod{*readyPod("a")}, crashingPod("b"), readyPod("b"))
+
+	err := waitForRollout(f, "default", testRC(2), time.Second)
+	assert.Nil(t, err)
+}
+
+func TestWaitForRolloutTimeout(t *testing.T) {
+	f := newFakeRolloutCore([]v1.Pod{*readyPod("a"), *crashingPod("b")})
+
+	err := waitForRollout(f, "default", testRC(2), 10*time.Millisecond)
+	assert.Equal(t, RolloutTimeoutError{
+		Name:    "test",
+		Ready:   1,
+		Desired: 2,
+		Timeout: 10 * time.Millisecond,
+		Pods: []broadway.PodStatus{{
+			Name:  "b",
+			Phase: "Running",
+			Containers: []broadway.ContainerStatus{{
+				Name:            "redis",
+				RestartCount:    3,
+				State:           "waiting: CrashLoopBackOff",
+				LastTermination: "terminated: Error, exit code 1",
+			}},
+		}},
+	}, err)
+	assert.Equal(t, "ReplicationController test has 1 of 2 pods ready after 10ms: "+
+		"b/redis waiting: CrashLoopBackOff (last terminated: Error, exit code 1)", err.Error())
+}
+
+func TestStepWaitsForReadyPods(t *testing.T) {
+	defer func(timeout time.Duration) { RolloutTimeout = timeout }(RolloutTimeout)
+	RolloutTimeout = time.Hour
+
+	f := newFakeRolloutCore(nil, crashingPod("a"))
+	f.PrependReactor("get", "*", notFoundReaction)
+
+	task := playbook.Task{Name: "step", WaitReady: true, Timeout: 10 * time.Millisecond}
+	step, err := NewDefaultStep(f, Owner{}, task, "default", mtemplate)
+	assert.Nil(t, err)
+	result, err := step.Deploy()
+	assert.IsType(t, RolloutTimeoutError{}, err)
+	assert.Equal(t, ChangeCreated, result.Change)
+}
+
+func TestStepDoesNotWaitWithoutWaitReady(t *testing.T) {
+	f := newFakeRolloutCore(nil)
+	f.PrependReactor("get", "*", notFoundReaction)
+
+	step, err := NewDefaultStep(f, Owner{}, playbook.Task{Name: "step"}, "default", mtemplate)
+	assert.Nil(t, err)
+	_, err = step.Deploy()
+	assert.Nil(t, err)
+	for _, action := range f.Actions() {
+		assert.NotEqual(t, "list", action.GetVerb())
+	}
+}
@@ -1,7 +1,11 @@
 package deployment
 
 import (
+	"fmt"
+	"time"
+
 	"k8s.io/kubernetes/pkg/api/v1"
+	coreclient "k8s.io/kubernetes/pkg/client/typed/generated/core/v1"
 	"k8s.io/kubernetes/pkg/runtime"
 
 	"github.com/namely/broadway/playbook"
@@ -10,41 +14,88 @@ import (
 // Step represents a deployment step
 type Step interface {
 	Task() playbook.Task
-	Deploy() error
+	Deploy() (ObjectResult, error)
+}
+
+// ManifestError is returned when an object of a manifest cannot be decoded
+// or is of a kind Broadway cannot deploy
+type ManifestError struct {
+	Manifest string
+	Err      error
+}
+
+func (e ManifestError) Error() string {
+	return fmt.Sprintf("manifest %s: %s", e.Manifest, e.Err)
+}
+
+// objectError names the manifest in an error about one of its objects.
+// Errors of the API server are returned as they are.
+func objectError(name string, err error) error {
+	if _, ok := err.(UnsupportedKindError); ok {
+		return ManifestError{Manifest: name, Err: err}
+	}
+	return err
 }
 
 // DefaultStep implements a deployment step
 type DefaultStep struct {
-	task   playbook.Task
-	object runtime.Object
+	client    coreclient.CoreInterface
+	task      playbook.Task
+	namespace string
+	object    runtime.Object
+	hash      string
+	// deadline, when set, is when the attempt at the task ends
+	deadline time.Time
 }
 
 var _ Step = &DefaultStep{}
 
-// NewDefaultStep creates a default step
-func NewDefaultStep(task playbook.Task, manifest string) (*DefaultStep, error) {
+// NewDefaultStep creates a default step that deploys manifest into namespace
+// with client. The object is labeled with its owner and task.
+func NewDefaultStep(client coreclient.CoreInterface, owner Owner, task playbook.Task, namespace, manifest string) (*DefaultStep, error) {
 	object, _, err := deserializer.Decode([]byte(manifest), &groupVersionKind, nil)
 	if err != nil {
 		return nil, err
 	}
+	if err := setOwnerLabels(object, owner, task.Name); err != nil {
+		return nil, err
+	}
+	hash, err := objectHash(object)
+	if err != nil {
+		return nil, err
+	}
+	if err := setRevisionAnnotation(object, owner.Revision); err != nil {
+		return nil, err
+	}
 	s := &DefaultStep{
-		object: object,
-		task:   task,
+		client:    client,
+		object:    object,
+		task:      task,
+		namespace: namespace,
+		hash:      hash,
 	}
 	return s, nil
 }
 
-// Deploy executes the deployment of a step
-func (s *DefaultStep) Deploy() error {
-	oGVK := s.object.GetObjectKind().GroupVersionKind()
-	if oGVK.Kind == "ReplicationController" {
-		rc := s.object.(*v1.ReplicationController)
-		_, err := client.ReplicationControllers("default").Create(rc)
-		if err != nil {
-			return err
-		}
+// Deploy applies the step's object, creating it or updating the live object.
+// Objects of a kind Broadway cannot deploy return an UnsupportedKindError.
+// When the task sets wait_ready, Deploy waits for the pods of a replication
+// controller to become ready.
+func (s *DefaultStep) Deploy() (ObjectResult, error) {
+	result := ObjectResult{
+		Kind: kindOf(s.object),
+		Name: nameOf(s.object),
+	}
+	change, err := applyObject(s.client, s.namespace, s.object, s.hash)
+	if err != nil {
+		return result, err
+	}
+	result.Change = change
+
+	if rc, ok := s.object.(*v1.ReplicationController); ok && s.task.WaitReady {
+		err = waitForRollout(s.client, s.namespace, rc, waitTimeout(s.task, RolloutTimeout, s.deadline))
 	}
-	return nil
+	return result, err
 }
 
 // Task returns the step task
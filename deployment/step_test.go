@@ -1 +1,116 @@
 package deployment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/client/testing/core"
+	"k8s.io/kubernetes/pkg/client/typed/generated/core/v1/fake"
+
+	"github.com/namely/broadway/playbook"
+)
+
+// fakeClaimsCore adds PersistentVolumeClaims to the vendored fake client
+type fakeClaimsCore struct {
+	*fake.FakeCore
+}
+
+func (c *fakeClaimsCore) PersistentVolumeClaims(namespace string) claimInterface {
+	return &fakeClaims{Fake: c.FakeCore, ns: namespace}
+}
+
+type fakeClaims struct {
+	Fake *fake.FakeCore
+	ns   string
+}
+
+func (c *fakeClaims) Create(claim *v1.PersistentVolumeClaim) (*v1.PersistentVolumeClaim, error) {
+	obj, err := c.Fake.
+		Invokes(core.NewCreateAction("persistentvolumeclaims", c.ns, claim), &v1.PersistentVolumeClaim{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1.PersistentVolumeClaim), err
+}
+
+func (c *fakeClaims) Update(claim *v1.PersistentVolumeClaim) (*v1.PersistentVolumeClaim, error) {
+	obj, err := c.Fake.
+		Invokes(core.NewUpdateAction("persistentvolumeclaims", c.ns, claim), &v1.PersistentVolumeClaim{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1.PersistentVolumeClaim), err
+}
+
+func (c *fakeClaims) Get(name string) (*v1.PersistentVolumeClaim, error) {
+	obj, err := c.Fake.
+		Invokes(core.NewGetAction("persistentvolumeclaims", c.ns, name), &v1.PersistentVolumeClaim{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1.PersistentVolumeClaim), err
+}
+
+func (c *fakeClaims) Delete(name string) error {
+	_, err := c.Fake.
+		Invokes(core.NewDeleteAction("persistentvolumeclaims", c.ns, name), &v1.PersistentVolumeClaim{})
+	return err
+}
+
+func TestStepDeployKinds(t *testing.T) {
+	testcases := []struct {
+		manifest string
+		resource string
+	}{
+		{mtemplate, "replicationcontrollers"},
+		{"apiVersion: v1\nkind: Service\nmetadata:\n  name: test\n", "services"},
+		{"apiVersion: v1\nkind: Pod\nmetadata:\n  name: test\n", "pods"},
+		{"apiVersion: v1\nkind: Secret\nmetadata:\n  name: test\n", "secrets"},
+		{"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n", "configmaps"},
+		{"apiVersion: v1\nkind: ServiceAccount\nmetadata:\n  name: test\n", "serviceaccounts"},
+		{"apiVersion: v1\nkind: Endpoints\nmetadata:\n  name: test\n", "endpoints"},
+		{"apiVersion: v1\nkind: PersistentVolumeClaim\nmetadata:\n  name: test\n", "persistentvolumeclaims"},
+	}
+
+	for _, testcase := range testcases {
+		f := &fake.FakeCore{Fake: &core.Fake{}}
+		f.AddReactor("get", "*", notFoundReaction)
+
+		step, err := NewDefaultStep(&fakeClaimsCore{f}, Owner{}, playbook.Task{Name: "step"}, "default", testcase.manifest)
+		assert.Nil(t, err)
+		result, err := step.Deploy()
+		assert.Nil(t, err, testcase.resource)
+		assert.Equal(t, ChangeCreated, result.Change)
+		assert.Equal(t, "test", result.Name)
+
+		actions := f.Actions()
+		if assert.Len(t, actions, 2, testcase.resource) {
+			assert.Equal(t, "create", actions[1].GetVerb())
+			assert.Equal(t, testcase.resource, actions[1].GetResource())
+			assert.Equal(t, "default", actions[1].GetNamespace())
+		}
+	}
+}
+
+func TestStepDeployUnsupportedKind(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+
+	step, err := NewDefaultStep(f, Owner{}, playbook.Task{Name: "step"}, "default", "apiVersion: v1\nkind: Node\nmetadata:\n  name: test\n")
+	assert.Nil(t, err)
+	_, err = step.Deploy()
+	assert.Equal(t, UnsupportedKindError{Kind: "Node"}, err)
+	assert.Len(t, f.Actions(), 0)
+}
+
+func TestStepDeployClaimsWithoutClaimsClient(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+
+	step, err := NewDefaultStep(f, Owner{}, playbook.Task{Name: "step"}, "default", "apiVersion: v1\nkind: PersistentVolumeClaim\nmetadata:\n  name: test\n")
+	assert.Nil(t, err)
+	_, err = step.Deploy()
+	assert.IsType(t, UnsupportedKindError{}, err)
+}
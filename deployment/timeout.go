@@ -0,0 +1,48 @@
+package deployment
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/namely/broadway/playbook"
+)
+
+// TaskTimeout bounds each attempt at a task that sets no timeout, its waits
+// for pods included
+var TaskTimeout = 30 * time.Minute
+
+// TaskTimeoutError is returned when an attempt at a task does not finish in
+// time
+type TaskTimeoutError struct {
+	Task    string
+	Timeout time.Duration
+}
+
+func (e TaskTimeoutError) Error() string {
+	return fmt.Sprintf("Task %s did not finish within %s", e.Task, e.Timeout)
+}
+
+// timeoutFor returns the task's timeout, or def if the task sets none
+func timeoutFor(task playbook.Task, def time.Duration) time.Duration {
+	if task.Timeout > 0 {
+		return task.Timeout
+	}
+	return def
+}
+
+// waitTimeout returns how long a step of task may wait for pods: def, or
+// the task's timeout, but not past the deadline of the attempt if it has one
+func waitTimeout(task playbook.Task, def time.Duration, deadline time.Time) time.Duration {
+	timeout := timeoutFor(task, def)
+	if deadline.IsZero() {
+		return timeout
+	}
+	left := deadline.Sub(time.Now())
+	if left < 0 {
+		left = 0
+	}
+	if left < timeout {
+		return left
+	}
+	return timeout
+}
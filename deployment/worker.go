@@ -0,0 +1,272 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/namely/broadway/instance"
+	"github.com/namely/broadway/manifest"
+	"github.com/namely/broadway/playbook"
+	"github.com/namely/broadway/store"
+)
+
+// PollInterval is how often idle workers look for requests pushed through
+// another Queue
+var PollInterval = time.Second
+
+// Pool runs deploy requests from a Queue in the background, moving each
+// instance through StatusDeploying to StatusDeployed or StatusError. An
+// instance is never deployed by two workers at once.
+type Pool struct {
+	Clusters *Clusters
+	// Playbooks holds the playbooks and manifests instances are deployed
+	// with. Each deployment uses the catalog current when it starts.
+	Playbooks *playbook.Registry
+
+	store store.Store
+	queue *Queue
+	size  int
+
+	mu     sync.Mutex
+	active map[string]bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewPool creates a Pool of size workers taking requests from q and
+// deploying them to clusters
+func NewPool(clusters *Clusters, s store.Store, q *Queue, size int) *Pool {
+	return &Pool{
+		Clusters:  clusters,
+		Playbooks: playbook.NewRegistry(nil, manifest.NewRegistry()),
+		store:     s,
+		queue:     q,
+		size:      size,
+		active:    map[string]bool{},
+	}
+}
+
+// Start launches the workers
+func (p *Pool) Start() {
+	p.stop = make(chan struct{})
+	for n := 0; n < p.size; n++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+}
+
+// Stop waits for running deployments to finish and stops the workers
+func (p *Pool) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for {
+		r, ok := p.next()
+		if !ok {
+			select {
+			case <-p.stop:
+				return
+			case <-p.queue.notify:
+			case <-time.After(PollInterval):
+			}
+			continue
+		}
+		renewing := make(chan struct{})
+		renewed := make(chan Request)
+		go func(r Request) {
+			renewed <- p.renew(r, renewing)
+		}(r)
+		p.process(r)
+		close(renewing)
+		r = <-renewed
+		if err := p.queue.Remove(r); err != nil {
+			log.Printf("Failed to dequeue deploy of %s/%s: %s\n", r.PlaybookID, r.InstanceID, err)
+		}
+		p.done(r)
+	}
+}
+
+// renew keeps the claim on the instance of r while it is deployed, until
+// stop is closed. It returns r with its last claim.
+func (p *Pool) renew(r Request, stop <-chan struct{}) Request {
+	ticker := time.NewTicker(claimTimeout() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return r
+		case <-ticker.C:
+			if err := p.queue.Renew(&r); err != nil {
+				log.Printf("Failed to renew claim on %s/%s: %s\n", r.PlaybookID, r.InstanceID, err)
+			}
+		}
+	}
+}
+
+// next claims the oldest request for an instance no other worker or server
+// is deploying. The request is removed from the queue once it was processed,
+// so that it is deployed again if this server stops first.
+func (p *Pool) next() (Request, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range p.queue.Pending() {
+		id := r.PlaybookID + "/" + r.InstanceID
+		if p.active[id] || !p.queue.Claim(&r) {
+			continue
+		}
+		p.active[id] = true
+		return r, true
+	}
+	return Request{}, false
+}
+
+func (p *Pool) done(r Request) {
+	p.mu.Lock()
+	delete(p.active, r.PlaybookID+"/"+r.InstanceID)
+	p.mu.Unlock()
+}
+
+// process deploys the instance named by r and records the outcome on it
+func (p *Pool) process(r Request) {
+	i, err := instance.Get(p.store, r.PlaybookID, r.InstanceID)
+	if err != nil {
+		log.Printf("Skipping deploy of %s/%s: %s\n", r.PlaybookID, r.InstanceID, err)
+		return
+	}
+	attrs := i.Attributes()
+	revision, err := NextRevision(p.store, r.PlaybookID, r.InstanceID, attrs.Revision)
+	if err != nil {
+		log.Printf("Skipping deploy of %s/%s: %s\n", r.PlaybookID, r.InstanceID, err)
+		return
+	}
+	attrs.Status = instance.StatusDeploying
+	attrs.Revision = revision
+	attrs.Reason = ""
+	attrs.FailedTask = ""
+	attrs.FailingPods = nil
+	attrs.FailedRevision = 0
+	attrs.RestoredRevision = 0
+	if err := i.Update(func(stored *instance.Attributes) { stored.SetDeployState(attrs) }); err != nil {
+		log.Printf("Failed to save %s/%s: %s\n", r.PlaybookID, r.InstanceID, err)
+		return
+	}
+
+	d, err := p.deploy(i, r)
+	if err != nil {
+		attrs.Status = instance.StatusError
+		attrs.Reason = err.Error()
+		if taskErr, ok := err.(TaskError); ok {
+			attrs.FailedTask = taskErr.Task
+			attrs.Reason = taskErr.Err.Error()
+			if rolloutErr, ok := taskErr.Err.(RolloutTimeoutError); ok {
+				attrs.FailingPods = rolloutErr.Pods
+			}
+		}
+		if d != nil && r.Rollback == 0 && d.Playbook.OnFailure == playbook.OnFailureRollback {
+			if err := p.rollback(d, i); err != nil {
+				attrs.Reason += "; rollback failed: " + err.Error()
+			}
+		}
+	} else {
+		attrs.Status = instance.StatusDeployed
+		attrs.Deployed = time.Now().UTC().Format(time.RFC3339)
+	}
+	// Only the deploy state is saved, so vars changed while the instance
+	// deployed are kept for the deployment queued with them. A rollback
+	// restores the vars of its revision.
+	restoredVars := err == nil && r.Rollback > 0
+	if err := i.Update(func(stored *instance.Attributes) {
+		stored.SetDeployState(attrs)
+		if restoredVars {
+			stored.Vars = attrs.Vars
+		}
+	}); err != nil {
+		log.Printf("Failed to save %s/%s: %s\n", r.PlaybookID, r.InstanceID, err)
+	}
+}
+
+// deploy deploys an instance, or applies one of its revisions again if r asks
+// for a rollback. The returned Deployment is nil if deploying failed before
+// anything was applied.
+func (p *Pool) deploy(i instance.Instance, r Request) (*Deployment, error) {
+	catalog := p.Playbooks.Catalog()
+	pb, ok := catalog.Playbooks[i.PlaybookID()]
+	if !ok {
+		return nil, fmt.Errorf("Playbook %s not found", i.PlaybookID())
+	}
+	var target *Revision
+	if r.Rollback > 0 {
+		var err error
+		if target, err = GetRevision(p.store, i.PlaybookID(), i.ID(), r.Rollback); err != nil {
+			return nil, err
+		}
+	}
+	attrs := i.Attributes()
+	cluster := p.Clusters.Resolve(attrs.Cluster, pb.Cluster)
+	client, err := p.Clusters.Client(cluster)
+	if err != nil {
+		return nil, err
+	}
+	attrs.Cluster = cluster
+
+	d := &Deployment{
+		Client:    client,
+		Playbook:  pb,
+		Manifests: catalog.ManifestsFor(pb.ID),
+		Store:     p.store,
+		User:      r.User,
+		Stop:      p.stop,
+
+		PlaybookVersion: catalog.Versions[pb.ID],
+	}
+	var result *Result
+	if target != nil {
+		result, err = d.RollbackInstance(i, target)
+		if err == nil {
+			attrs.Vars = target.Vars
+		}
+	} else {
+		result, err = d.DeployInstance(i)
+	}
+	if result == nil {
+		return nil, err
+	}
+	attrs.PlaybookVersion = d.PlaybookVersion
+	return d, err
+}
+
+// rollback applies the last good revision of an instance again after its
+// deployment d failed, deleting what d applied that the revision lacks
+func (p *Pool) rollback(d *Deployment, i instance.Instance) error {
+	attrs := i.Attributes()
+	target, err := LastGoodRevision(p.store, i.PlaybookID(), i.ID(), attrs.Revision)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return errors.New("no successful deployment to roll back to")
+	}
+	failed, err := GetRevision(p.store, i.PlaybookID(), i.ID(), attrs.Revision)
+	if err != nil {
+		return err
+	}
+	if _, err := d.restore(target, &Revision{}); err != nil {
+		return err
+	}
+	if err := d.removeLeftovers(failed, target); err != nil {
+		return err
+	}
+	attrs.FailedRevision = attrs.Revision
+	attrs.RestoredRevision = target.Number
+	attrs.PlaybookVersion = target.PlaybookVersion
+
+	failed.RolledBackTo = target.Number
+	return SaveRevision(p.store, i.PlaybookID(), i.ID(), failed)
+}
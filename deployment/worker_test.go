@@ -0,0 +1,401 @@
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/client/testing/core"
+	coreclient "k8s.io/kubernetes/pkg/client/typed/generated/core/v1"
+	"k8s.io/kubernetes/pkg/client/typed/generated/core/v1/fake"
+	"k8s.io/kubernetes/pkg/runtime"
+
+	"github.com/namely/broadway/broadway"
+	"github.com/namely/broadway/instance"
+	"github.com/namely/broadway/manifest"
+	"github.com/namely/broadway/playbook"
+	"github.com/namely/broadway/store"
+)
+
+var goodPlaybook = playbook.Playbook{
+	ID:    "good",
+	Tasks: []playbook.Task{{Name: "Deploy", Manifests: []string{"good"}}},
+}
+
+var badPlaybook = playbook.Playbook{
+	ID: "bad",
+	Tasks: []playbook.Task{
+		{Name: "Deploy", Manifests: []string{"good"}},
+		{Name: "Break", Manifests: []string{"bad"}},
+	},
+}
+
+// newTestPool creates a Pool deploying the good and bad playbooks, replaced
+// or joined by playbooks
+func newTestPool(t *testing.T, clusters *Clusters, s store.Store, playbooks ...playbook.Playbook) *Pool {
+	good, err := manifest.New("good", mtemplate)
+	assert.Nil(t, err)
+	bad, err := manifest.New("bad", "apiVersion: v1\nkind: Node\nmetadata:\n  name: test\n")
+	assert.Nil(t, err)
+	manifests := manifest.NewRegistry()
+	manifests.Add(good)
+	manifests.Add(bad)
+
+	pool := NewPool(clusters, s, NewQueue(s), 2)
+	pool.Playbooks = playbook.NewRegistry(append([]playbook.Playbook{goodPlaybook, badPlaybook}, playbooks...), manifests)
+	return pool
+}
+
+func waitForStatus(t *testing.T, s store.Store, playbookID, ID string, status instance.Status) instance.Instance {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		i, err := instance.Get(s, playbookID, ID)
+		if err == nil && i.Status() == status {
+			return i
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Instance %s/%s never reached status %q", playbookID, ID, status)
+	return nil
+}
+
+func TestPoolDeploysQueuedInstances(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+
+	s := store.NewMemory()
+	assert.Nil(t, instance.New(s, &instance.Attributes{PlaybookID: "good", ID: "1"}).Save())
+	assert.Nil(t, instance.New(s, &instance.Attributes{PlaybookID: "bad", ID: "1"}).Save())
+
+	pool := newTestPool(t, SingleCluster(f), s)
+	pool.Start()
+	defer pool.Stop()
+	assert.Nil(t, pool.queue.Push("good", "1"))
+	assert.Nil(t, pool.queue.Push("bad", "1"))
+
+	good := waitForStatus(t, s, "good", "1", instance.StatusDeployed)
+	assert.Equal(t, NamespaceFor("good", "1"), good.Attributes().Namespace)
+	assert.Equal(t, DefaultCluster, good.Attributes().Cluster)
+	assert.NotEmpty(t, good.Attributes().Deployed)
+
+	bad := waitForStatus(t, s, "bad", "1", instance.StatusError)
+	assert.Equal(t, "Break", bad.Attributes().FailedTask)
+	assert.Contains(t, bad.Attributes().Reason, "manifest bad: Unsupported manifest kind: Node")
+	assert.Empty(t, bad.Attributes().Deployed)
+	assert.Len(t, pool.queue.Pending(), 0)
+}
+
+func TestPoolRecordsUploadedPlaybookVersion(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+
+	s := store.NewMemory()
+	assert.Nil(t, instance.New(s, &instance.Attributes{PlaybookID: "uploaded", ID: "1"}).Save())
+
+	pool := newTestPool(t, SingleCluster(f), s)
+	assert.Nil(t, pool.Playbooks.Put(&playbook.Version{
+		ID:        "uploaded",
+		Number:    3,
+		Playbook:  "id: uploaded\nname: Uploaded\ntasks:\n  - name: Deploy\n    manifests: [rc]\n",
+		Manifests: map[string]string{"rc": mtemplate},
+	}))
+	pool.process(Request{PlaybookID: "uploaded", InstanceID: "1"})
+
+	i, err := instance.Get(s, "uploaded", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, instance.Status(instance.StatusDeployed), i.Status())
+	assert.Equal(t, 3, i.Attributes().PlaybookVersion)
+	rev, err := GetRevision(s, "uploaded", "1", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, rev.PlaybookVersion)
+}
+
+func TestPoolRecordsAttempts(t *testing.T) {
+	unavailable := errors.NewServiceUnavailable("restarting")
+	failures := 1
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+	f.AddReactor("create", "replicationcontrollers", func(action core.Action) (bool, runtime.Object, error) {
+		if failures == 0 {
+			return false, nil, nil
+		}
+		failures--
+		return true, nil, unavailable
+	})
+
+	s := store.NewMemory()
+	assert.Nil(t, instance.New(s, &instance.Attributes{PlaybookID: "retried", ID: "1"}).Save())
+	pool := newTestPool(t, SingleCluster(f), s, playbook.Playbook{
+		ID:    "retried",
+		Tasks: []playbook.Task{{Name: "Deploy", Manifests: []string{"good"}, Retries: 2, RetryDelay: time.Millisecond}},
+	})
+	pool.process(Request{PlaybookID: "retried", InstanceID: "1"})
+
+	rev, err := GetRevision(s, "retried", "1", 1)
+	assert.Nil(t, err)
+	if assert.Len(t, rev.Tasks, 1) {
+		assert.Equal(t, []Attempt{
+			{Number: 1, Error: unavailable.Error()},
+			{Number: 2},
+		}, rev.Tasks[0].Attempts)
+	}
+}
+
+func TestPoolUnknownPlaybook(t *testing.T) {
+	s := store.NewMemory()
+	i := instance.New(s, &instance.Attributes{PlaybookID: "missing", ID: "1"})
+	assert.Nil(t, i.Save())
+
+	pool := newTestPool(t, SingleCluster(nil), s)
+	pool.process(Request{PlaybookID: "missing", InstanceID: "1"})
+
+	i, err := instance.Get(s, "missing", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, instance.Status(instance.StatusError), i.Status())
+	assert.Equal(t, "Playbook missing not found", i.Attributes().Reason)
+}
+
+func TestPoolDoesNotDeployAnInstanceTwiceAtOnce(t *testing.T) {
+	s := store.NewMemory()
+	pool := newTestPool(t, SingleCluster(nil), s)
+	assert.Nil(t, pool.queue.Push("good", "1"))
+	assert.Nil(t, pool.queue.Push("good", "1"))
+
+	r, ok := pool.next()
+	assert.True(t, ok)
+	assert.Equal(t, "1", r.InstanceID)
+	_, ok = pool.next()
+	assert.False(t, ok)
+
+	assert.Nil(t, pool.queue.Remove(r))
+	pool.done(r)
+	_, ok = pool.next()
+	assert.True(t, ok)
+}
+
+func TestPoolRoutesInstancesToClusters(t *testing.T) {
+	staging := &fake.FakeCore{Fake: &core.Fake{}}
+	staging.AddReactor("get", "*", notFoundReaction)
+	qa := &fake.FakeCore{Fake: &core.Fake{}}
+	qa.AddReactor("get", "*", notFoundReaction)
+	clusters := &Clusters{
+		Default: "staging",
+		clients: map[string]coreclient.CoreInterface{"staging": staging, "qa": qa},
+	}
+
+	s := store.NewMemory()
+	onqa := goodPlaybook
+	onqa.ID = "onqa"
+	onqa.Cluster = "qa"
+	pool := newTestPool(t, clusters, s, onqa)
+
+	testcases := []struct {
+		attrs   instance.Attributes
+		cluster string
+		client  *fake.FakeCore
+	}{
+		{instance.Attributes{PlaybookID: "good", ID: "default"}, "staging", staging},
+		{instance.Attributes{PlaybookID: "onqa", ID: "playbook"}, "qa", qa},
+		{instance.Attributes{PlaybookID: "onqa", ID: "override", Cluster: "staging"}, "staging", staging},
+	}
+	for _, testcase := range testcases {
+		attrs := testcase.attrs
+		assert.Nil(t, instance.New(s, &attrs).Save())
+		staging.ClearActions()
+		qa.ClearActions()
+
+		pool.process(Request{PlaybookID: attrs.PlaybookID, InstanceID: attrs.ID})
+
+		i, err := instance.Get(s, attrs.PlaybookID, attrs.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, instance.Status(instance.StatusDeployed), i.Status(), attrs.ID)
+		assert.Equal(t, testcase.cluster, i.Attributes().Cluster, attrs.ID)
+		assert.NotEmpty(t, testcase.client.Actions(), attrs.ID)
+	}
+}
+
+func TestPoolUnknownCluster(t *testing.T) {
+	s := store.NewMemory()
+	pool := newTestPool(t, SingleCluster(nil), s)
+	assert.Nil(t, instance.New(s, &instance.Attributes{PlaybookID: "good", ID: "1", Cluster: "prod"}).Save())
+
+	pool.process(Request{PlaybookID: "good", InstanceID: "1"})
+
+	i, err := instance.Get(s, "good", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, instance.Status(instance.StatusError), i.Status())
+	assert.Equal(t, "Cluster prod is not configured", i.Attributes().Reason)
+}
+
+func TestPoolRecordsRevisions(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+
+	s := store.NewMemory()
+	vars := map[string]string{"version": "1"}
+	assert.Nil(t, instance.New(s, &instance.Attributes{PlaybookID: "good", ID: "1", Vars: vars}).Save())
+
+	pool := newTestPool(t, SingleCluster(f), s)
+	pool.Start()
+	defer pool.Stop()
+	assert.Nil(t, pool.queue.PushRequest(Request{PlaybookID: "good", InstanceID: "1", User: "alice"}))
+
+	i := waitForStatus(t, s, "good", "1", instance.StatusDeployed)
+	assert.Equal(t, 1, i.Attributes().Revision)
+	rev, err := GetRevision(s, "good", "1", 1)
+	assert.Nil(t, err)
+	if assert.NotNil(t, rev) {
+		assert.Equal(t, "alice", rev.User)
+		assert.Equal(t, OutcomeSucceeded, rev.Outcome)
+		assert.Equal(t, vars, rev.Vars)
+		assert.Equal(t, []RevisionTask{{
+			Name:      "Deploy",
+			Outcome:   OutcomeSucceeded,
+			Manifests: []string{mtemplate},
+			Objects:   []ObjectResult{{Kind: "ReplicationController", Name: "test", Change: ChangeCreated}},
+		}}, rev.Tasks)
+	}
+}
+
+func TestPoolRollsBackFailedDeployments(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+
+	s := store.NewMemory()
+	assert.Nil(t, instance.New(s, &instance.Attributes{PlaybookID: "bad", ID: "1", DeployState: broadway.DeployState{Revision: 4}}).Save())
+	assert.Nil(t, instance.New(s, &instance.Attributes{PlaybookID: "bad", ID: "2"}).Save())
+	assert.Nil(t, SaveRevision(s, "bad", "1", &Revision{
+		Number:  4,
+		Outcome: OutcomeSucceeded,
+		Tasks:   []RevisionTask{{Name: "Deploy", Outcome: OutcomeSucceeded, Manifests: []string{mtemplate}}},
+	}))
+
+	rollback := badPlaybook
+	rollback.OnFailure = playbook.OnFailureRollback
+	pool := newTestPool(t, SingleCluster(f), s, rollback)
+	pool.Start()
+	defer pool.Stop()
+	assert.Nil(t, pool.queue.Push("bad", "1"))
+	assert.Nil(t, pool.queue.Push("bad", "2"))
+
+	rolledBack := waitForStatus(t, s, "bad", "1", instance.StatusError)
+	attrs := rolledBack.Attributes()
+	assert.Equal(t, 5, attrs.Revision)
+	assert.Equal(t, 5, attrs.FailedRevision)
+	assert.Equal(t, 4, attrs.RestoredRevision)
+	assert.Equal(t, "manifest bad: Unsupported manifest kind: Node", attrs.Reason)
+	failed, err := GetRevision(s, "bad", "1", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, OutcomeFailed, failed.Outcome)
+	assert.Equal(t, 4, failed.RolledBackTo)
+
+	first := waitForStatus(t, s, "bad", "2", instance.StatusError)
+	attrs = first.Attributes()
+	assert.Equal(t, 0, attrs.RestoredRevision)
+	assert.Equal(t, "manifest bad: Unsupported manifest kind: Node; rollback failed: no successful deployment to roll back to", attrs.Reason)
+}
+
+func TestPoolRollsBackOnRequest(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+
+	s := store.NewMemory()
+	oldVars := map[string]string{"version": "1"}
+	assert.Nil(t, instance.New(s, &instance.Attributes{
+		PlaybookID:  "good",
+		ID:          "1",
+		DeployState: broadway.DeployState{Revision: 2},
+		Vars:        map[string]string{"version": "2"},
+	}).Save())
+	assert.Nil(t, SaveRevision(s, "good", "1", &Revision{
+		Number:  1,
+		Outcome: OutcomeSucceeded,
+		Vars:    oldVars,
+		Tasks:   []RevisionTask{{Name: "Deploy", Outcome: OutcomeSucceeded, Manifests: []string{mtemplate}}},
+	}))
+
+	pool := newTestPool(t, SingleCluster(f), s)
+	pool.Start()
+	defer pool.Stop()
+	assert.Nil(t, pool.queue.PushRequest(Request{PlaybookID: "good", InstanceID: "1", User: "bob", Rollback: 1}))
+
+	i := waitForStatus(t, s, "good", "1", instance.StatusDeployed)
+	assert.Equal(t, 3, i.Attributes().Revision)
+	assert.Equal(t, oldVars, i.Attributes().Vars)
+	rev, err := GetRevision(s, "good", "1", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, rev.RollbackOf)
+	assert.Equal(t, "bob", rev.User)
+	assert.Equal(t, OutcomeSucceeded, rev.Outcome)
+}
+
+func TestPoolKeepsVarsChangedWhileDeploying(t *testing.T) {
+	s := store.NewMemory()
+	assert.Nil(t, instance.New(s, &instance.Attributes{PlaybookID: "good", ID: "1", Vars: map[string]string{"version": "1"}}).Save())
+
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+	f.AddReactor("create", "replicationcontrollers", func(action core.Action) (bool, runtime.Object, error) {
+		changed, err := instance.Get(s, "good", "1")
+		assert.Nil(t, err)
+		changed.Attributes().Vars = map[string]string{"version": "2"}
+		assert.Nil(t, changed.Save())
+		return false, nil, nil
+	})
+
+	pool := newTestPool(t, SingleCluster(f), s)
+	pool.process(Request{PlaybookID: "good", InstanceID: "1"})
+
+	i, err := instance.Get(s, "good", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, instance.Status(instance.StatusDeployed), i.Status())
+	assert.Equal(t, map[string]string{"version": "2"}, i.Attributes().Vars)
+	rev, err := GetRevision(s, "good", "1", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"version": "1"}, rev.Vars)
+}
+
+func TestPoolNumbersRevisionsAfterStoredHistory(t *testing.T) {
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", notFoundReaction)
+
+	s := store.NewMemory()
+	assert.Nil(t, instance.New(s, &instance.Attributes{PlaybookID: "good", ID: "1"}).Save())
+	assert.Nil(t, SaveRevision(s, "good", "1", &Revision{Number: 2, Outcome: OutcomeSucceeded}))
+
+	pool := newTestPool(t, SingleCluster(f), s)
+	pool.process(Request{PlaybookID: "good", InstanceID: "1"})
+
+	i, err := instance.Get(s, "good", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, i.Attributes().Revision)
+	rev, err := GetRevision(s, "good", "1", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, OutcomeSucceeded, rev.Outcome)
+	assert.Empty(t, rev.Tasks)
+}
+
+func TestPoolKeepsRequestsQueuedWhileDeploying(t *testing.T) {
+	s := store.NewMemory()
+	pool := newTestPool(t, SingleCluster(nil), s)
+	assert.Nil(t, pool.queue.Push("good", "1"))
+	assert.Nil(t, pool.queue.Push("good", "1"))
+
+	r, ok := pool.next()
+	assert.True(t, ok)
+	assert.Len(t, pool.queue.Pending(), 2)
+
+	// Another server sees the instance claimed, and takes none of its
+	// requests
+	other := newTestPool(t, SingleCluster(nil), s)
+	_, ok = other.next()
+	assert.False(t, ok)
+
+	// Once the claim is abandoned, as by a crash, the request is deployed again
+	abandoned := time.Now().Add(-2*ClaimTimeout).UTC().Format(time.RFC3339Nano) + " " + r.key
+	assert.Nil(t, s.SetValue(claimKey(r), abandoned))
+	again, ok := other.next()
+	assert.True(t, ok)
+	assert.Equal(t, r.key, again.key)
+}
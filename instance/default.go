@@ -2,6 +2,7 @@ package instance
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/namely/broadway/store"
 )
@@ -22,6 +23,29 @@ func New(s store.Store, attrs *Attributes) Instance {
 	return instance
 }
 
+// NotFoundError is returned when no instance is stored at a path
+type NotFoundError struct {
+	Path string
+}
+
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("Instance with path: %s was not found", e.Path)
+}
+
+// Get looks up the Instance stored under a playbookID and instance id
+func Get(s store.Store, playbookID, ID string) (Instance, error) {
+	path := "/broadway/instances/" + playbookID + "/" + ID
+	v := s.Value(path)
+	if v == "" {
+		return nil, NotFoundError{Path: path}
+	}
+	var attrs Attributes
+	if err := json.Unmarshal([]byte(v), &attrs); err != nil {
+		return nil, err
+	}
+	return New(s, &attrs), nil
+}
+
 // List looks up all Instances stored under a given playbookID
 func List(s store.Store, playbookID string) ([]Instance, error) {
 	instances := []Instance{}
@@ -88,6 +112,19 @@ func (instance *defaultInstance) Save() (err error) {
 	return nil
 }
 
+// Update applies change to the instance, then to the stored instance read
+// again, and saves that. An instance removed from the store is not saved
+// again.
+func (instance *defaultInstance) Update(change func(*Attributes)) error {
+	change(instance.Attributes())
+	stored, err := Get(instance.store, instance.PlaybookID(), instance.ID())
+	if err != nil {
+		return err
+	}
+	change(stored.Attributes())
+	return stored.Save()
+}
+
 // Destroy removes the stored instance
 func (instance *defaultInstance) Destroy() error {
 	return instance.store.Delete(instance.path())
@@ -23,3 +23,42 @@ func TestSavingInstance(t *testing.T) {
 
 	assert.Equal(t, "test", instance.PlaybookID)
 }
+
+func TestGet(t *testing.T) {
+	s := store.NewMemory()
+	i := New(s, &Attributes{PlaybookID: "test", ID: "get", Status: StatusDeployed})
+	err := i.Save()
+	assert.Nil(t, err)
+
+	found, err := Get(s, "test", "get")
+	assert.Nil(t, err)
+	assert.Equal(t, "get", found.ID())
+	assert.Equal(t, Status(StatusDeployed), found.Status())
+
+	_, err = Get(s, "test", "missing")
+	assert.IsType(t, NotFoundError{}, err)
+}
+
+func TestUpdateKeepsFieldsSavedSinceRead(t *testing.T) {
+	s := store.NewMemory()
+	i := New(s, &Attributes{PlaybookID: "test", ID: "update", Vars: map[string]string{"version": "1"}})
+	assert.Nil(t, i.Save())
+
+	changed, err := Get(s, "test", "update")
+	assert.Nil(t, err)
+	changed.Attributes().Vars = map[string]string{"version": "2"}
+	assert.Nil(t, changed.Save())
+
+	err = i.Update(func(attrs *Attributes) { attrs.Status = StatusDeployed })
+	assert.Nil(t, err)
+	assert.Equal(t, Status(StatusDeployed), i.Status())
+	stored, err := Get(s, "test", "update")
+	assert.Nil(t, err)
+	assert.Equal(t, Status(StatusDeployed), stored.Status())
+	assert.Equal(t, "2", stored.Attributes().Vars["version"])
+
+	assert.Nil(t, i.Destroy())
+	assert.IsType(t, NotFoundError{}, i.Update(func(attrs *Attributes) {}))
+	_, err = Get(s, "test", "update")
+	assert.IsType(t, NotFoundError{}, err)
+}
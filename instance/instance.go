@@ -2,6 +2,8 @@ package instance
 
 import (
 	"encoding/json"
+
+	"github.com/namely/broadway/broadway"
 )
 
 // Status represents the lifecycle state of one instance
@@ -27,6 +29,16 @@ type Attributes struct {
 	Created    string            `json:"created"`
 	Vars       map[string]string `json:"vars"`
 	Status     Status            `json:"status"`
+	Cluster    string            `json:"cluster,omitempty"`
+	broadway.DeployState
+}
+
+// SetDeployState copies the fields a deployment records, its Status,
+// Cluster and DeployState, from attrs. Vars and Created are left alone.
+func (a *Attributes) SetDeployState(attrs *Attributes) {
+	a.Status = attrs.Status
+	a.Cluster = attrs.Cluster
+	a.DeployState = attrs.DeployState
 }
 
 // JSON serializes a set of instance attributes
@@ -45,6 +57,10 @@ type Instance interface {
 	PlaybookID() string
 	ID() string
 	Save() error
+	// Update applies change to the instance's attributes and saves them
+	// over the stored instance read again, so fields change leaves alone
+	// keep what was saved since the instance was read
+	Update(change func(*Attributes)) error
 	Destroy() error
 
 	Attributes() *Attributes
@@ -1,32 +1,75 @@
 package main
 
 import (
-	"fmt"
 	"log"
 	"os"
+	"strings"
 
-	"github.com/namely/broadway/instance"
+	"github.com/namely/broadway/deployment"
+	"github.com/namely/broadway/manifest"
 	"github.com/namely/broadway/playbook"
 	"github.com/namely/broadway/server"
 	"github.com/namely/broadway/store"
 )
 
+// deployWorkers is the number of instances deployed at the same time
+const deployWorkers = 4
+
+// clustersENV is the name of an environment variable pointing at a cluster
+// targets file
+const clustersENV string = "BROADWAY_CLUSTERS"
+
+// manifestEnvENV is the name of an environment variable listing, comma
+// separated, the environment variables manifests may read with env
+const manifestEnvENV string = "BROADWAY_MANIFEST_ENV"
+
 func main() {
 	/*
 		args := os.Args
 		yamlFileDescriptor := args[1:][0]
 	*/
-	playbooks, err := playbook.LoadPlaybookFolder("playbooks/")
+	if err := playbook.SetManifestRoot("manifests/"); err != nil {
+		log.Fatal(err)
+	}
+	if names := os.Getenv(manifestEnvENV); names != "" {
+		manifest.AllowEnv(strings.Split(names, ",")...)
+	}
+	s := store.New()
+	clusters, err := loadClusters()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	fmt.Printf("%v+\n", playbooks)
-	fmt.Println(instance.StatusNew)
-	server := server.New(store.New())
+	playbooks, err := playbook.LoadRegistry("playbooks/", s, clusters.Names())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Loaded %d playbooks\n", len(playbooks.Catalog().Playbooks))
+
+	pool := deployment.NewPool(clusters, s, deployment.NewQueue(s), deployWorkers)
+	pool.Playbooks = playbooks
+	pool.Start()
+	go playbooks.Watch(make(chan struct{}))
+
+	server := server.New(s, clusters)
+	server.SetPlaybooks(playbooks)
 	err = server.Run(os.Getenv("HOST"))
 	if err != nil {
 		panic(err)
 	}
 
 }
+
+// loadClusters reads the cluster targets file named by BROADWAY_CLUSTERS, or
+// configures a single cluster from the KUBE_* environment variables
+func loadClusters() (*deployment.Clusters, error) {
+	if path := os.Getenv(clustersENV); path != "" {
+		return deployment.LoadClusters(path)
+	}
+	client, err := deployment.NewClient(deployment.ClientConfigFromEnv())
+	if err != nil {
+		return nil, err
+	}
+	return deployment.SingleCluster(client), nil
+}
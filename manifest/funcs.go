@@ -0,0 +1,147 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// dns1123MaxLength is the longest a DNS-1123 label, and so most Kubernetes
+// object names, may be
+const dns1123MaxLength = 63
+
+var dns1123Invalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// envAllowlist holds the environment variables manifests may read with env
+var envAllowlist = map[string]bool{}
+
+// AllowEnv lets manifests read the named environment variables with env
+func AllowEnv(names ...string) {
+	for _, name := range names {
+		envAllowlist[name] = true
+	}
+}
+
+// funcs are the functions available to every manifest template, in
+// addition to the text/template builtins
+var funcs = template.FuncMap{
+	"default":  defaultValue,
+	"required": required,
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+	"trunc":    trunc,
+	"dns1123":  dns1123,
+	"b64enc":   b64enc,
+	"sha256":   sha256Sum,
+	"quote":    quote,
+	"toYaml":   toYaml,
+	"toJson":   toJSON,
+	"indent":   indent,
+	"env":      env,
+}
+
+// isEmpty reports whether a template value is missing or blank
+func isEmpty(value interface{}) bool {
+	return value == nil || fmt.Sprint(value) == ""
+}
+
+// defaultValue returns value, or def if value is missing or empty, e.g.
+// {{ .replicas | default "1" }}
+func defaultValue(def, value interface{}) interface{} {
+	if isEmpty(value) {
+		return def
+	}
+	return value
+}
+
+// required fails rendering with message if value is missing or empty, e.g.
+// {{ required "version is needed" .version }}
+func required(message string, value interface{}) (interface{}, error) {
+	if isEmpty(value) {
+		return nil, errors.New(message)
+	}
+	return value, nil
+}
+
+// trunc shortens s to at most n characters
+func trunc(n int, s string) string {
+	if n < 0 {
+		return s
+	}
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+// dns1123 turns s into a valid DNS-1123 label: lowercase alphanumerics and
+// dashes, starting and ending with an alphanumeric, at most 63 characters
+func dns1123(s string) string {
+	label := dns1123Invalid.ReplaceAllString(strings.ToLower(s), "-")
+	label = strings.Trim(label, "-")
+	if len(label) > dns1123MaxLength {
+		label = strings.TrimRight(label[:dns1123MaxLength], "-")
+	}
+	return label
+}
+
+func b64enc(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// sha256Sum returns the hex encoded sha256 of s
+func sha256Sum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// quote returns value as a double quoted string, safe to use as a YAML
+// scalar
+func quote(value interface{}) string {
+	if value == nil {
+		return `""`
+	}
+	return strconv.Quote(fmt.Sprint(value))
+}
+
+func toYaml(value interface{}) (string, error) {
+	encoded, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(encoded), "\n"), nil
+}
+
+func toJSON(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// indent prefixes every line of s with n spaces, e.g. to nest toYaml output
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// env returns the value of an environment variable, if it was allowed with
+// AllowEnv
+func env(name string) (string, error) {
+	if !envAllowlist[name] {
+		return "", fmt.Errorf("environment variable %s is not allowed in manifests", name)
+	}
+	return os.Getenv(name), nil
+}
@@ -0,0 +1,92 @@
+package manifest
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func render(t *testing.T, content string, vars map[string]string) (string, error) {
+	m, err := New("test", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.Execute(vars, Builtins{InstanceID: "pr-12", PlaybookID: "web", Revision: 3})
+}
+
+func TestFuncs(t *testing.T) {
+	vars := map[string]string{
+		"name":    "Web_Server.v2",
+		"empty":   "",
+		"version": "dc231ba",
+	}
+	testcases := []struct {
+		content  string
+		expected string
+	}{
+		{`{{ .version | default "latest" }}`, "dc231ba"},
+		{`{{ .empty | default "latest" }}`, "latest"},
+		{`{{ .missing | default "latest" }}`, "latest"},
+		{`{{ required "version is needed" .version }}`, "dc231ba"},
+		{`{{ .name | lower }}`, "web_server.v2"},
+		{`{{ .name | upper }}`, "WEB_SERVER.V2"},
+		{`{{ .version | trunc 3 }}`, "dc2"},
+		{`{{ .version | trunc 20 }}`, "dc231ba"},
+		{`{{ .name | dns1123 }}`, "web-server-v2"},
+		{`{{ "--Feature/ABC--" | dns1123 }}`, "feature-abc"},
+		{`{{ "secret" | b64enc }}`, "c2VjcmV0"},
+		{`{{ "abc" | sha256 }}`, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{`{{ .version | quote }}`, `"dc231ba"`},
+		{`{{ "say \"hi\"" | quote }}`, `"say \"hi\""`},
+		{`{{ .missing | quote }}`, `""`},
+		{`{{ .broadway | toJson }}`, `{"created":"","instance_id":"pr-12","namespace":"","playbook_id":"web","revision":3,"user":""}`},
+		{`{{ .version | toYaml }}`, "dc231ba"},
+		{`{{ .broadway.revision | toYaml }}`, "3"},
+		{`{{ "a: 1\nb: 2" | indent 4 }}`, "    a: 1\n    b: 2"},
+		{"labels:\n{{ .broadway | toYaml | indent 2 }}", "labels:\n  created: \"\"\n  instance_id: pr-12\n  namespace: \"\"\n  playbook_id: web\n  revision: 3\n  user: \"\""},
+	}
+	for _, testcase := range testcases {
+		out, err := render(t, testcase.content, vars)
+		assert.Nil(t, err, testcase.content)
+		assert.Equal(t, testcase.expected, out, testcase.content)
+	}
+}
+
+func TestRequiredFunc(t *testing.T) {
+	_, err := render(t, `{{ required "version is needed" .version }}`, map[string]string{})
+	assert.IsType(t, ExecuteError{}, err)
+	assert.Contains(t, err.Error(), "version is needed")
+}
+
+func TestDNS1123Length(t *testing.T) {
+	label := dns1123(strings.Repeat("a", 62) + "-b")
+	assert.Equal(t, strings.Repeat("a", 62), label)
+	assert.Len(t, dns1123(strings.Repeat("x", 100)), 63)
+}
+
+func TestEnvFunc(t *testing.T) {
+	defer func() { envAllowlist = map[string]bool{} }()
+	if err := os.Setenv("BROADWAY_TEST_REGION", "us-east-1"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("BROADWAY_TEST_REGION")
+
+	_, err := render(t, `{{ env "BROADWAY_TEST_REGION" }}`, nil)
+	assert.IsType(t, ExecuteError{}, err)
+	assert.Contains(t, err.Error(), "environment variable BROADWAY_TEST_REGION is not allowed in manifests")
+
+	AllowEnv("BROADWAY_TEST_REGION")
+	out, err := render(t, `{{ env "BROADWAY_TEST_REGION" }}`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "us-east-1", out)
+}
+
+func TestDefaultFuncStrict(t *testing.T) {
+	m, err := New("test", `{{ index . "replicas" | default "1" }}`)
+	assert.Nil(t, err)
+	out, err := m.ExecuteStrict(map[string]string{}, Builtins{})
+	assert.Nil(t, err)
+	assert.Equal(t, "1", out)
+}
@@ -2,6 +2,10 @@ package manifest
 
 import (
 	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"text/template"
 )
 
@@ -12,9 +16,68 @@ type Manifest struct {
 	template *template.Template
 }
 
-// New creates a new Manifest object and parses the template
+// MissingVarError is returned by ExecuteStrict when a manifest uses a var
+// that is not set
+type MissingVarError struct {
+	Manifest string
+	Var      string
+	Line     int
+}
+
+func (e MissingVarError) Error() string {
+	return fmt.Sprintf("manifest %s: missing var %s at line %d", e.Manifest, e.Var, e.Line)
+}
+
+// ExecuteError is returned when a manifest template fails to execute
+type ExecuteError struct {
+	Manifest string
+	Err      error
+}
+
+func (e ExecuteError) Error() string {
+	return fmt.Sprintf("manifest %s: %s", e.Manifest, e.Err)
+}
+
+// BuiltinsKey is the reserved var manifests read Broadway's own values from,
+// e.g. {{ .broadway.instance_id }}
+const BuiltinsKey = "broadway"
+
+// Builtins are the values every manifest can read under .broadway. They
+// take precedence over any instance var of the same name.
+type Builtins struct {
+	InstanceID string
+	PlaybookID string
+	Namespace  string
+	Created    string
+	Revision   int
+	User       string
+}
+
+// data returns the template data for vars and builtins
+func data(vars map[string]string, builtins Builtins) map[string]interface{} {
+	d := make(map[string]interface{}, len(vars)+1)
+	for k, v := range vars {
+		d[k] = v
+	}
+	d[BuiltinsKey] = map[string]interface{}{
+		"instance_id": builtins.InstanceID,
+		"playbook_id": builtins.PlaybookID,
+		"namespace":   builtins.Namespace,
+		"created":     builtins.Created,
+		"revision":    builtins.Revision,
+		"user":        builtins.User,
+	}
+	return d
+}
+
+// missingKey matches the error text/template reports for a missing map key
+// in missingkey=error mode
+var missingKey = regexp.MustCompile(`:(\d+):\d+: executing .*: map has no entry for key "(.*)"$`)
+
+// New creates a new Manifest object and parses the template, with the
+// functions in funcs available to it
 func New(id, content string) (*Manifest, error) {
-	t, err := template.New(id).Parse(content)
+	t, err := template.New(id).Funcs(funcs).Parse(content)
 	if err != nil {
 		return nil, err
 	}
@@ -22,12 +85,57 @@ func New(id, content string) (*Manifest, error) {
 	return &Manifest{ID: id, template: t}, nil
 }
 
-// Execute executes template with variables
-func (m *Manifest) Execute(vars map[string]string) string {
-	var b bytes.Buffer
-	err := m.template.Execute(&b, vars)
+// Execute executes template with variables and builtins. Vars the manifest
+// uses but are not set render as "<no value>".
+func (m *Manifest) Execute(vars map[string]string, builtins Builtins) (string, error) {
+	return m.execute(m.template, vars, builtins)
+}
+
+// ExecuteStrict executes template with variables and builtins, returning a
+// MissingVarError for the first var the manifest uses that is not set
+func (m *Manifest) ExecuteStrict(vars map[string]string, builtins Builtins) (string, error) {
+	t, err := m.template.Clone()
 	if err != nil {
-		return ""
+		return "", ExecuteError{Manifest: m.ID, Err: err}
+	}
+	return m.execute(t.Option("missingkey=error"), vars, builtins)
+}
+
+func (m *Manifest) execute(t *template.Template, vars map[string]string, builtins Builtins) (string, error) {
+	var b bytes.Buffer
+	err := t.Execute(&b, data(vars, builtins))
+	if err == nil {
+		return b.String(), nil
+	}
+	if match := missingKey.FindStringSubmatch(err.Error()); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		return "", MissingVarError{Manifest: m.ID, Var: match[2], Line: line}
+	}
+	return "", ExecuteError{Manifest: m.ID, Err: err}
+}
+
+// documentSeparator matches the lines separating YAML documents
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*(#.*)?$`)
+
+// Documents splits a rendered manifest into its YAML documents, in order.
+// Documents holding only blank lines and comments are left out. A JSON
+// manifest is a single document.
+func Documents(rendered string) []string {
+	var documents []string
+	for _, document := range documentSeparator.Split(rendered, -1) {
+		if !isEmptyDocument(document) {
+			documents = append(documents, document)
+		}
+	}
+	return documents
+}
+
+func isEmptyDocument(document string) bool {
+	for _, line := range strings.Split(document, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return false
+		}
 	}
-	return b.String()
+	return true
 }
@@ -11,7 +11,83 @@ func TestExecute(t *testing.T) {
 
 	assert.Nil(t, err)
 
-	out := m.Execute(map[string]string{"test": "hello!"})
+	out, err := m.Execute(map[string]string{"test": "hello!"}, Builtins{})
 
+	assert.Nil(t, err)
 	assert.Equal(t, "hello!", out)
 }
+
+func TestExecuteMissingVar(t *testing.T) {
+	m, err := New("test", `image: {{ .image }}`)
+	assert.Nil(t, err)
+
+	out, err := m.Execute(map[string]string{}, Builtins{})
+	assert.Nil(t, err)
+	assert.Equal(t, "image: <no value>", out)
+}
+
+func TestExecuteStrict(t *testing.T) {
+	m, err := New("web-rc", "kind: ReplicationController\nimage: {{ .version }}\nassets: {{ .assets_version }}\n")
+	assert.Nil(t, err)
+
+	out, err := m.ExecuteStrict(map[string]string{"version": "1", "assets_version": "2"}, Builtins{})
+	assert.Nil(t, err)
+	assert.Equal(t, "kind: ReplicationController\nimage: 1\nassets: 2\n", out)
+
+	_, err = m.ExecuteStrict(map[string]string{"version": "1"}, Builtins{})
+	assert.Equal(t, MissingVarError{Manifest: "web-rc", Var: "assets_version", Line: 3}, err)
+	assert.EqualError(t, err, "manifest web-rc: missing var assets_version at line 3")
+
+	out, err = m.Execute(map[string]string{"version": "1"}, Builtins{})
+	assert.Nil(t, err, "strict mode should not leak into Execute")
+	assert.Equal(t, "kind: ReplicationController\nimage: 1\nassets: <no value>\n", out)
+}
+
+func TestExecuteError(t *testing.T) {
+	m, err := New("test", `{{ index .list 3 }}`)
+	assert.Nil(t, err)
+
+	_, err = m.Execute(map[string]string{"list": "ab"}, Builtins{})
+	assert.IsType(t, ExecuteError{}, err)
+	assert.Contains(t, err.Error(), "manifest test: ")
+}
+
+func TestExecuteBuiltins(t *testing.T) {
+	m, err := New("test", "{{.broadway.playbook_id}}-{{.broadway.instance_id}} {{.broadway.namespace}} {{.broadway.created}} {{.broadway.revision}} {{.broadway.user}}")
+	assert.Nil(t, err)
+
+	builtins := Builtins{
+		InstanceID: "pr-12",
+		PlaybookID: "web",
+		Namespace:  "web-pr-12",
+		Created:    "2016-05-10T14:02:11Z",
+		Revision:   3,
+		User:       "alice",
+	}
+	out, err := m.ExecuteStrict(map[string]string{"broadway": "overridden"}, builtins)
+	assert.Nil(t, err)
+	assert.Equal(t, "web-pr-12 web-pr-12 2016-05-10T14:02:11Z 3 alice", out)
+}
+
+func TestDocuments(t *testing.T) {
+	rendered := `---
+kind: ReplicationController
+metadata:
+  name: web
+--- # the service
+kind: Service
+metadata:
+  name: web
+---
+# nothing left
+---
+`
+	assert.Equal(t, []string{
+		"\nkind: ReplicationController\nmetadata:\n  name: web\n",
+		"\nkind: Service\nmetadata:\n  name: web\n",
+	}, Documents(rendered))
+
+	json := `{"kind": "Service", "metadata": {"name": "web---1"}}`
+	assert.Equal(t, []string{json}, Documents(json))
+	assert.Empty(t, Documents("# empty\n\n"))
+}
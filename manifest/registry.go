@@ -0,0 +1,192 @@
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Registry holds the parsed manifests of a manifests directory, keyed by
+// name, and which playbooks reference each of them
+type Registry struct {
+	manifests map[string]*Manifest
+	users     map[string][]string
+}
+
+// LoadError lists every manifest that failed to load, as FileErrors
+type LoadError struct {
+	Errors []error
+}
+
+func (e LoadError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for n, err := range e.Errors {
+		messages[n] = "  " + err.Error()
+	}
+	return fmt.Sprintf("Failed to load %d manifests:\n%s", len(e.Errors), strings.Join(messages, "\n"))
+}
+
+// FileError reports a manifest file that failed to load
+type FileError struct {
+	Path string
+	Name string
+	Err  error
+}
+
+func (e FileError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		manifests: map[string]*Manifest{},
+		users:     map[string][]string{},
+	}
+}
+
+// LoadRegistry parses every file under root with one of extensions, in
+// order of preference. A manifest is named by its path relative to root,
+// without the extension, e.g. "web-rc" or "web/rc". Files that fail to parse
+// are all reported in a LoadError, and left out of the Registry.
+func LoadRegistry(root string, extensions ...string) (*Registry, error) {
+	r := NewRegistry()
+	paths := map[string]string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, ok := manifestName(root, path, extensions)
+		if !ok {
+			return nil
+		}
+		if other, ok := paths[name]; ok && preferred(other, path, extensions) {
+			return nil
+		}
+		paths[name] = path
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, name := range sortedKeys(paths) {
+		content, err := ioutil.ReadFile(paths[name])
+		if err != nil {
+			errs = append(errs, FileError{Path: paths[name], Name: name, Err: err})
+			continue
+		}
+		m, err := New(name, string(content))
+		if err != nil {
+			errs = append(errs, FileError{Path: paths[name], Name: name, Err: err})
+			continue
+		}
+		r.Add(m)
+	}
+	if len(errs) > 0 {
+		return r, LoadError{Errors: errs}
+	}
+	return r, nil
+}
+
+// manifestName returns the name of the manifest at path, if it has one of
+// extensions
+func manifestName(root, path string, extensions []string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", false
+	}
+	for _, ext := range extensions {
+		if strings.HasSuffix(rel, ext) {
+			return filepath.ToSlash(strings.TrimSuffix(rel, ext)), true
+		}
+	}
+	return "", false
+}
+
+// preferred reports whether path a has an extension listed before that of b
+func preferred(a, b string, extensions []string) bool {
+	for _, ext := range extensions {
+		if strings.HasSuffix(a, ext) {
+			return true
+		}
+		if strings.HasSuffix(b, ext) {
+			return false
+		}
+	}
+	return false
+}
+
+// Add registers a manifest under its ID
+func (r *Registry) Add(m *Manifest) {
+	r.manifests[m.ID] = m
+}
+
+// Get looks up a manifest by name
+func (r *Registry) Get(name string) (*Manifest, bool) {
+	m, ok := r.manifests[name]
+	return m, ok
+}
+
+// Manifests returns the manifests by name, for a Deployment. The map must
+// not be modified.
+func (r *Registry) Manifests() map[string]*Manifest {
+	return r.manifests
+}
+
+// Names returns the names of all manifests, sorted
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.manifests))
+	for name := range r.manifests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reference records that the playbook playbookID uses the named manifests.
+// Names with no manifest are returned in an error.
+func (r *Registry) Reference(playbookID string, names ...string) error {
+	var missing []string
+	for _, name := range names {
+		if _, ok := r.manifests[name]; !ok {
+			missing = append(missing, name)
+			continue
+		}
+		if !contains(r.users[name], playbookID) {
+			r.users[name] = append(r.users[name], playbookID)
+			sort.Strings(r.users[name])
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Playbook %s uses missing manifests: %s", playbookID, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Users returns the IDs of the playbooks that reference a manifest, sorted
+func (r *Registry) Users(name string) []string {
+	return r.users[name]
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
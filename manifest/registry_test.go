@@ -0,0 +1,84 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeManifests(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestLoadRegistry(t *testing.T) {
+	root := writeManifests(t, map[string]string{
+		"web-rc.yml":    "kind: ReplicationController",
+		"web-rc.json":   `{"kind": "Service"}`,
+		"config.json":   `{"kind": "ConfigMap"}`,
+		"redis/rc.yml":  "kind: ReplicationController",
+		"README.md":     "not a manifest",
+		"web/notes.txt": "not a manifest either",
+	})
+	defer os.RemoveAll(root)
+
+	r, err := LoadRegistry(root, ".yml", ".json")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"config", "redis/rc", "web-rc"}, r.Names())
+	m, ok := r.Get("web-rc")
+	if assert.True(t, ok) {
+		out, _ := m.Execute(nil, Builtins{})
+		assert.Equal(t, "kind: ReplicationController", out)
+	}
+	assert.Len(t, r.Manifests(), 3)
+}
+
+func TestLoadRegistryReportsEveryError(t *testing.T) {
+	root := writeManifests(t, map[string]string{
+		"good.yml":   "name: {{.name}}",
+		"broken.yml": "name: {{.name",
+		"worse.yml":  "name: {{end}}",
+	})
+	defer os.RemoveAll(root)
+
+	r, err := LoadRegistry(root, ".yml")
+	if assert.IsType(t, LoadError{}, err) {
+		assert.Len(t, err.(LoadError).Errors, 2)
+		assert.Contains(t, err.Error(), "Failed to load 2 manifests:\n  "+filepath.Join(root, "broken.yml"))
+		assert.Contains(t, err.Error(), filepath.Join(root, "worse.yml"))
+	}
+	assert.Equal(t, []string{"good"}, r.Names())
+}
+
+func TestRegistryReference(t *testing.T) {
+	r := NewRegistry()
+	for _, name := range []string{"web-rc", "redis-rc"} {
+		m, _ := New(name, "")
+		r.Add(m)
+	}
+
+	assert.Nil(t, r.Reference("web", "web-rc", "redis-rc"))
+	assert.Nil(t, r.Reference("api", "redis-rc"))
+	assert.Nil(t, r.Reference("web", "redis-rc"))
+	assert.EqualError(t, r.Reference("worker", "web-rc", "worker-rc", "cron-pod"),
+		"Playbook worker uses missing manifests: worker-rc, cron-pod")
+
+	assert.Equal(t, []string{"web", "worker"}, r.Users("web-rc"))
+	assert.Equal(t, []string{"api", "web"}, r.Users("redis-rc"))
+	assert.Empty(t, r.Users("worker-rc"))
+}
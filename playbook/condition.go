@@ -0,0 +1,291 @@
+package playbook
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Keywords of the condition language that refer to the instance rather than
+// to one of its vars
+const (
+	// ConditionNewDeployment is true the first time an instance is deployed
+	ConditionNewDeployment = "new_deployment"
+	// ConditionRedeployment is true once an instance has been deployed before
+	ConditionRedeployment = "redeployment"
+)
+
+// State is what a task condition is evaluated against
+type State struct {
+	Vars map[string]string
+	// FirstDeploy is set until the instance has been deployed successfully
+	FirstDeploy bool
+}
+
+// Condition is a parsed task `when` expression. Expressions combine
+//
+//	version                  var is set and not empty
+//	env == production        var equals a value
+//	env != "staging env"     var differs from a value
+//	new_deployment           first deploy of the instance
+//	redeployment             any later deploy
+//
+// with `and`, `or`, `not` (or `&&`, `||`, `!`) and parentheses. Values may be
+// quoted with single or double quotes.
+type Condition struct {
+	expr conditionNode
+}
+
+// ParseCondition parses a `when` expression. An empty expression is always
+// true.
+func ParseCondition(expr string) (*Condition, error) {
+	if strings.TrimSpace(expr) == "" {
+		return &Condition{}, nil
+	}
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("Unexpected %s at position %d", t, t.pos)
+	}
+	return &Condition{expr: node}, nil
+}
+
+// Eval reports whether the condition holds in state
+func (c *Condition) Eval(state State) bool {
+	if c.expr == nil {
+		return true
+	}
+	return c.expr.eval(state)
+}
+
+// ShouldRun parses the task's `when` condition and evaluates it in state
+func (t Task) ShouldRun(state State) (bool, error) {
+	c, err := ParseCondition(t.When)
+	if err != nil {
+		return false, err
+	}
+	return c.Eval(state), nil
+}
+
+type conditionNode interface {
+	eval(State) bool
+}
+
+type orNode struct{ left, right conditionNode }
+type andNode struct{ left, right conditionNode }
+type notNode struct{ operand conditionNode }
+type presentNode struct{ name string }
+type compareNode struct {
+	name, value string
+	equal       bool
+}
+type deployNode struct{ first bool }
+
+func (n orNode) eval(s State) bool  { return n.left.eval(s) || n.right.eval(s) }
+func (n andNode) eval(s State) bool { return n.left.eval(s) && n.right.eval(s) }
+func (n notNode) eval(s State) bool { return !n.operand.eval(s) }
+func (n presentNode) eval(s State) bool {
+	return s.Vars[n.name] != ""
+}
+func (n compareNode) eval(s State) bool {
+	return (s.Vars[n.name] == n.value) == n.equal
+}
+func (n deployNode) eval(s State) bool { return s.FirstDeploy == n.first }
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenEqual
+	tokenNotEqual
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.-/:", r)
+}
+
+func tokenizeCondition(expr string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case r == '=' && i+1 < len(runes) && runes[i+1] == '=':
+			tokens = append(tokens, token{tokenEqual, "==", i})
+			i += 2
+		case r == '!' && i+1 < len(runes) && runes[i+1] == '=':
+			tokens = append(tokens, token{tokenNotEqual, "!=", i})
+			i += 2
+		case r == '!':
+			tokens = append(tokens, token{tokenNot, "!", i})
+			i++
+		case r == '&' && i+1 < len(runes) && runes[i+1] == '&':
+			tokens = append(tokens, token{tokenAnd, "&&", i})
+			i += 2
+		case r == '|' && i+1 < len(runes) && runes[i+1] == '|':
+			tokens = append(tokens, token{tokenOr, "||", i})
+			i += 2
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("Unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{tokenString, string(runes[i+1 : end]), i})
+			i = end + 1
+		case isWordRune(r):
+			end := i
+			for end < len(runes) && isWordRune(runes[end]) {
+				end++
+			}
+			word := string(runes[i:end])
+			kind := tokenWord
+			switch word {
+			case "and":
+				kind = tokenAnd
+			case "or":
+				kind = tokenOr
+			case "not":
+				kind = tokenNot
+			}
+			tokens = append(tokens, token{kind, word, i})
+			i = end
+		default:
+			return nil, fmt.Errorf("Unexpected %q at position %d", r, i)
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(runes)}), nil
+}
+
+type conditionParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *conditionParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *conditionParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *conditionParser) parseOr() (conditionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (conditionNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseUnary() (conditionNode, error) {
+	if p.peek().kind == tokenNot {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *conditionParser) parsePrimary() (conditionNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("Expected \")\" at position %d, found %s", closing.pos, closing)
+		}
+		return node, nil
+	case tokenWord:
+	default:
+		return nil, fmt.Errorf("Unexpected %s at position %d", t, t.pos)
+	}
+
+	op := p.peek()
+	if op.kind != tokenEqual && op.kind != tokenNotEqual {
+		switch t.value {
+		case ConditionNewDeployment:
+			return deployNode{first: true}, nil
+		case ConditionRedeployment:
+			return deployNode{first: false}, nil
+		}
+		return presentNode{name: t.value}, nil
+	}
+	p.next()
+	value := p.next()
+	if value.kind != tokenWord && value.kind != tokenString {
+		return nil, fmt.Errorf("Expected a value at position %d, found %s", value.pos, value)
+	}
+	return compareNode{name: t.value, value: value.value, equal: op.kind == tokenEqual}, nil
+}
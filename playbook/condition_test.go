@@ -0,0 +1,101 @@
+package playbook
+
+import "testing"
+
+func TestConditionEval(t *testing.T) {
+	state := State{
+		Vars: map[string]string{
+			"env":     "production",
+			"version": "1.2",
+			"owner":   "",
+			"team":    "web team",
+		},
+		FirstDeploy: true,
+	}
+
+	testcases := []struct {
+		expr     string
+		expected bool
+	}{
+		{"", true},
+		{"version", true},
+		{"owner", false},
+		{"missing", false},
+		{"env == production", true},
+		{"env == 'staging'", false},
+		{"env != staging", true},
+		{`team == "web team"`, true},
+		{"version == 1.2", true},
+		{"new_deployment", true},
+		{"redeployment", false},
+		{"not new_deployment", false},
+		{"!owner", true},
+		{"env == production and version", true},
+		{"env == production && owner", false},
+		{"owner or version", true},
+		{"owner || missing", false},
+		{"not (owner or missing)", true},
+		{"owner or version and env == staging", false},
+		{"(owner or version) and env == production", true},
+		{"new_deployment and env == production", true},
+	}
+
+	for _, testcase := range testcases {
+		c, err := ParseCondition(testcase.expr)
+		if err != nil {
+			t.Errorf("Condition %q\nExpected: No error\nActual:\n%s", testcase.expr, err)
+			continue
+		}
+		if actual := c.Eval(state); actual != testcase.expected {
+			t.Errorf("Condition %q\nExpected: %t\nActual: %t", testcase.expr, testcase.expected, actual)
+		}
+	}
+}
+
+func TestConditionRedeployment(t *testing.T) {
+	c, err := ParseCondition("redeployment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Eval(State{FirstDeploy: false}) {
+		t.Error("Expected redeployment to hold when the instance was deployed before")
+	}
+}
+
+func TestParseConditionFailures(t *testing.T) {
+	testcases := []struct {
+		expr        string
+		expectedErr string
+	}{
+		{"env ==", "Expected a value at position 6, found end of expression"},
+		{"env == and", `Expected a value at position 7, found "and"`},
+		{"(version", `Expected ")" at position 8, found end of expression`},
+		{"version)", `Unexpected ")" at position 7`},
+		{"version version", `Unexpected "version" at position 8`},
+		{"and version", `Unexpected "and" at position 0`},
+		{"env == 'prod", "Unterminated string at position 7"},
+		{"env = prod", `Unexpected '=' at position 4`},
+	}
+
+	for _, testcase := range testcases {
+		_, err := ParseCondition(testcase.expr)
+		if err == nil {
+			t.Errorf("Condition %q\nExpected:\n%s\nActual: No error", testcase.expr, testcase.expectedErr)
+			continue
+		}
+		if err.Error() != testcase.expectedErr {
+			t.Errorf("Condition %q\nExpected:\n%s\nActual:\n%s", testcase.expr, testcase.expectedErr, err)
+		}
+	}
+}
+
+func TestTaskShouldRun(t *testing.T) {
+	task := Task{Name: "Database Setup", When: "new_deployment"}
+	run, err := task.ShouldRun(State{FirstDeploy: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run {
+		t.Error("Expected task to be skipped on redeployment")
+	}
+}
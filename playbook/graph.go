@@ -0,0 +1,118 @@
+package playbook
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxParallel is how many tasks of a playbook run at the same time
+// when it does not set max_parallel
+var DefaultMaxParallel = 4
+
+// Parallelism returns how many tasks of the playbook may run at the same
+// time
+func (p Playbook) Parallelism() int {
+	if p.MaxParallel > 0 {
+		return p.MaxParallel
+	}
+	return DefaultMaxParallel
+}
+
+// usesDependencies reports whether any task of the playbook declares
+// depends_on, even an empty one
+func (p Playbook) usesDependencies() bool {
+	for _, task := range p.Tasks {
+		if task.DependsOn != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Dependencies returns the indexes of the tasks each task depends on, by
+// task index. A task that declares depends_on depends on the tasks it lists
+// only, and on none with an empty depends_on: []. A task without depends_on
+// depends on the one before it, so that tasks keep their order.
+func (p Playbook) Dependencies() ([][]int, error) {
+	deps := make([][]int, len(p.Tasks))
+	for n, task := range p.Tasks {
+		if task.DependsOn == nil && n > 0 {
+			deps[n] = []int{n - 1}
+		}
+	}
+	if !p.usesDependencies() {
+		return deps, nil
+	}
+
+	index := map[string]int{}
+	for n, task := range p.Tasks {
+		if _, ok := index[task.Name]; ok {
+			return nil, fmt.Errorf("Task name %s is used twice; task names must be unique with depends_on", task.Name)
+		}
+		index[task.Name] = n
+	}
+	for n, task := range p.Tasks {
+		for _, name := range task.DependsOn {
+			dep, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("Task %s depends on unknown task %s", task.Name, name)
+			}
+			if dep == n {
+				return nil, fmt.Errorf("Task %s depends on itself", task.Name)
+			}
+			deps[n] = append(deps[n], dep)
+		}
+	}
+	if cycle := findCycle(deps); cycle != nil {
+		names := make([]string, len(cycle))
+		for n, task := range cycle {
+			names[n] = p.Tasks[task].Name
+		}
+		return nil, fmt.Errorf("Tasks depend on each other in a cycle: %s", strings.Join(names, " -> "))
+	}
+	return deps, nil
+}
+
+// Task states while looking for cycles
+const (
+	unvisited = iota
+	visiting
+	visited
+)
+
+// findCycle returns the tasks of a dependency cycle in deps, starting and
+// ending with the same task, or nil if there is none
+func findCycle(deps [][]int) []int {
+	state := make([]int, len(deps))
+	var path []int
+	var visit func(n int) []int
+	visit = func(n int) []int {
+		state[n] = visiting
+		path = append(path, n)
+		for _, dep := range deps[n] {
+			switch state[dep] {
+			case visiting:
+				for start, task := range path {
+					if task == dep {
+						return append(append([]int{}, path[start:]...), dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[n] = visited
+		return nil
+	}
+	for n := range deps {
+		if state[n] == unvisited {
+			if cycle := visit(n); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package playbook
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDependencies(t *testing.T) {
+	testcases := []struct {
+		scenario string
+		tasks    []Task
+		expected [][]int
+	}{
+		{
+			"Tasks in order",
+			[]Task{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+			[][]int{nil, {0}, {1}},
+		},
+		{
+			"Tasks with depends_on",
+			[]Task{
+				{Name: "postgres"},
+				{Name: "redis", DependsOn: []string{}},
+				{Name: "web", DependsOn: []string{"postgres", "redis"}},
+			},
+			[][]int{nil, nil, {0, 1}},
+		},
+		{
+			"Tasks with and without depends_on",
+			[]Task{
+				{Name: "postgres"},
+				{Name: "migrate"},
+				{Name: "redis", DependsOn: []string{}},
+				{Name: "web", DependsOn: []string{"migrate", "redis"}},
+				{Name: "smoke"},
+			},
+			[][]int{nil, {0}, nil, {1, 2}, {3}},
+		},
+	}
+	for _, testcase := range testcases {
+		deps, err := Playbook{Tasks: testcase.tasks}.Dependencies()
+		if err != nil {
+			t.Errorf("Scenario %s: %s", testcase.scenario, err)
+			continue
+		}
+		if !reflect.DeepEqual(testcase.expected, deps) {
+			t.Errorf("Scenario %s\nExpected:\n%v\nActual:\n%v", testcase.scenario, testcase.expected, deps)
+		}
+	}
+}
+
+func TestDependenciesFailures(t *testing.T) {
+	testcases := []struct {
+		scenario    string
+		tasks       []Task
+		expectedErr string
+	}{
+		{
+			"Unknown task",
+			[]Task{{Name: "a", DependsOn: []string{"b"}}},
+			"Task a depends on unknown task b",
+		},
+		{
+			"Itself",
+			[]Task{{Name: "a", DependsOn: []string{"a"}}},
+			"Task a depends on itself",
+		},
+		{
+			"Duplicate names",
+			[]Task{{Name: "a"}, {Name: "a"}, {Name: "b", DependsOn: []string{"a"}}},
+			"Task name a is used twice; task names must be unique with depends_on",
+		},
+		{
+			"Cycle",
+			[]Task{
+				{Name: "a"},
+				{Name: "b", DependsOn: []string{"a", "d"}},
+				{Name: "c", DependsOn: []string{"b"}},
+				{Name: "d", DependsOn: []string{"c"}},
+			},
+			"Tasks depend on each other in a cycle: b -> d -> c -> b",
+		},
+	}
+	for _, testcase := range testcases {
+		_, err := Playbook{Tasks: testcase.tasks}.Dependencies()
+		if err == nil || err.Error() != testcase.expectedErr {
+			t.Errorf("Scenario %s\nExpected:\n%s\nActual:\n%v", testcase.scenario, testcase.expectedErr, err)
+		}
+	}
+}
+
+func TestValidateTasksRejectsCycles(t *testing.T) {
+	p := Playbook{
+		ID:   "web",
+		Name: "Web",
+		Tasks: []Task{
+			{Name: "a", Manifests: []string{"test-manifest"}, DependsOn: []string{"b"}},
+			{Name: "b", Manifests: []string{"test-manifest"}, DependsOn: []string{"a"}},
+		},
+	}
+	expected := "Tasks depend on each other in a cycle: a -> b -> a"
+	if err := p.Validate(); err == nil || err.Error() != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%v", expected, err)
+	}
+}
+
+func TestParallelism(t *testing.T) {
+	if n := (Playbook{}).Parallelism(); n != DefaultMaxParallel {
+		t.Errorf("Expected default parallelism %d, got %d", DefaultMaxParallel, n)
+	}
+	if n := (Playbook{MaxParallel: 2}).Parallelism(); n != 2 {
+		t.Errorf("Expected parallelism 2, got %d", n)
+	}
+	p := Playbook{ID: "web", Name: "Web", MaxParallel: -1, Tasks: []Task{{Name: "a", Manifests: []string{"test-manifest"}}}}
+	expected := "Playbook max_parallel must not be negative"
+	if err := p.Validate(); err == nil || err.Error() != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%v", expected, err)
+	}
+}
@@ -1,41 +1,144 @@
 package playbook
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v2"
+
+	"github.com/namely/broadway/manifest"
 )
 
 // Meta contains optional metadata keys associated with this playbook
 type Meta struct {
-	Team  string `yaml:"team"`
-	Email string `yaml:"email"`
-	Slack string `yaml:"slack"`
+	Team  string `yaml:"team" json:"team"`
+	Email string `yaml:"email" json:"email"`
+	Slack string `yaml:"slack" json:"slack"`
 }
 
 // Task represents a step in the playbook, for example, running migrations
 // or deploying services.
 type Task struct {
-	Name        string   `yaml:"name"`
-	Manifests   []string `yaml:"manifests,omitempty"`
-	PodManifest string   `yaml:"pod_manifest,omitempty"`
-	WaitFor     []string `yaml:"wait_for,omitempty"`
-	When        string   `yaml:"when,omitempty"`
+	Name        string   `yaml:"name" json:"name"`
+	Manifests   []string `yaml:"manifests,omitempty" json:"manifests,omitempty"`
+	PodManifest string   `yaml:"pod_manifest,omitempty" json:"pod_manifest,omitempty"`
+	WaitFor     []string `yaml:"wait_for,omitempty" json:"wait_for,omitempty"`
+	When        string   `yaml:"when,omitempty" json:"when,omitempty"`
+	// DependsOn names the tasks that must finish before the task starts.
+	// Without it the task waits for the one before it; an empty list starts
+	// it at once. See Playbook.Dependencies.
+	DependsOn []string `yaml:"depends_on,omitempty" json:"depends_on,omitempty"`
+	// WaitReady makes the task wait until the pods of its replication
+	// controllers are ready. A controller keeps its running pods when its
+	// template changes, so this only tells a new template works when the
+	// controller is created or gains replicas.
+	WaitReady bool `yaml:"wait_ready,omitempty" json:"wait_ready,omitempty"`
+	// Timeout bounds each attempt at the task, its waits for pods included,
+	// e.g. "5m". Zero uses the deployment defaults.
+	Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// Retries is how many more times the task is deployed after failing
+	// with a retryable error, such as a conflict or a server error
+	Retries int `yaml:"retries,omitempty" json:"retries,omitempty"`
+	// RetryDelay is how long the task waits before its first retry, e.g.
+	// "10s". The delay doubles with each retry. Zero uses the deployment
+	// default.
+	RetryDelay time.Duration `yaml:"retry_delay,omitempty" json:"retry_delay,omitempty"`
+}
+
+// plainTask has the fields of Task without its marshalling methods
+type plainTask Task
+
+// taskJSON is a task with its durations written as playbooks write them,
+// e.g. "2m0s"
+type taskJSON struct {
+	plainTask
+	// DependsOn is kept when empty, as an empty depends_on differs from none
+	DependsOn  *[]string `json:"depends_on,omitempty"`
+	Timeout    string    `json:"timeout,omitempty"`
+	RetryDelay string    `json:"retry_delay,omitempty"`
 }
 
+// MarshalJSON writes the task's timeout and retry_delay as durations, not
+// nanoseconds, and keeps an empty depends_on
+func (t Task) MarshalJSON() ([]byte, error) {
+	encoded := taskJSON{plainTask: plainTask(t)}
+	if t.DependsOn != nil {
+		encoded.DependsOn = &t.DependsOn
+	}
+	if t.Timeout != 0 {
+		encoded.Timeout = t.Timeout.String()
+	}
+	if t.RetryDelay != 0 {
+		encoded.RetryDelay = t.RetryDelay.String()
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON reads a task written by MarshalJSON
+func (t *Task) UnmarshalJSON(data []byte) error {
+	var decoded taskJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*t = Task(decoded.plainTask)
+	if decoded.DependsOn != nil {
+		t.DependsOn = *decoded.DependsOn
+	}
+	var err error
+	if decoded.Timeout != "" {
+		if t.Timeout, err = time.ParseDuration(decoded.Timeout); err != nil {
+			return err
+		}
+	}
+	if decoded.RetryDelay != "" {
+		if t.RetryDelay, err = time.ParseDuration(decoded.RetryDelay); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Values a pod manifest task may wait for its pod to reach
+const (
+	WaitForSuccess = "success"
+	WaitForFailure = "failure"
+	WaitForRunning = "running"
+)
+
 // Playbook configures a set of tasks to be automated
 type Playbook struct {
-	ID    string   `yaml:"id"`
-	Name  string   `yaml:"name"`
-	Meta  Meta     `yaml:"meta"`
-	Vars  []string `yaml:"vars"`
-	Tasks []Task   `yaml:"tasks"`
+	ID    string `yaml:"id" json:"id"`
+	Name  string `yaml:"name" json:"name"`
+	Meta  Meta   `yaml:"meta" json:"meta"`
+	Vars  []Var  `yaml:"vars" json:"vars"`
+	Tasks []Task `yaml:"tasks" json:"tasks"`
+	// Cluster names the cluster target instances are deployed to. Empty means
+	// the server's default cluster.
+	Cluster string `yaml:"cluster,omitempty" json:"cluster,omitempty"`
+	// OnFailure set to "rollback" re-applies an instance's last good
+	// deployment when a deployment fails
+	OnFailure string `yaml:"on_failure,omitempty" json:"on_failure,omitempty"`
+	// StrictVars makes a deployment fail when a manifest uses a var the
+	// instance does not set, instead of rendering it empty
+	StrictVars bool `yaml:"strict_vars,omitempty" json:"strict_vars,omitempty"`
+	// MaxParallel bounds how many tasks run at the same time. Zero uses
+	// DefaultMaxParallel.
+	MaxParallel int `yaml:"max_parallel,omitempty" json:"max_parallel,omitempty"`
 }
 
+// OnFailureRollback is the on_failure value that rolls failed deployments
+// back
+const OnFailureRollback = "rollback"
+
+// ErrorsID is reserved for GET /playbooks/errors, which lists the playbook
+// and manifest files that failed to load, so no playbook may use it
+const ErrorsID = "errors"
+
 // ManifestRoot points to the folder where manifests are found, relative to
 // playbooks/
 var ManifestRoot = "../manifests/"
@@ -43,6 +146,10 @@ var ManifestRoot = "../manifests/"
 // ManifestExtension is added to each task manifest item to make a filename
 var ManifestExtension = ".yml"
 
+// JSONManifestExtension is tried when a manifest has no file with
+// ManifestExtension
+var JSONManifestExtension = ".json"
+
 // SetManifestRoot ensures a folder exists, then sets ManifestRoot to that
 // folder.
 func SetManifestRoot(newRoot string) error {
@@ -53,43 +160,109 @@ func SetManifestRoot(newRoot string) error {
 	return nil
 }
 
+// ManifestPath returns the file of a manifest item in ManifestRoot: the item
+// with ManifestExtension, or else with JSONManifestExtension. The error is
+// that of the ManifestExtension file if neither exists.
+func ManifestPath(name string) (string, error) {
+	path := filepath.Join(ManifestRoot, name+ManifestExtension)
+	_, err := os.Stat(path)
+	if err == nil {
+		return path, nil
+	}
+	jsonPath := filepath.Join(ManifestRoot, name+JSONManifestExtension)
+	if _, jsonErr := os.Stat(jsonPath); jsonErr == nil {
+		return jsonPath, nil
+	}
+	return "", err
+}
+
+// ManifestNames returns the manifests and pod manifests the playbook's tasks
+// use, each once, in order of use
+func (p Playbook) ManifestNames() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, task := range p.Tasks {
+		for _, name := range append(task.Manifests[:len(task.Manifests):len(task.Manifests)], task.PodManifest) {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
 // ManifestsPresent iterates through the Manifests and PodManifest items on a
 // task, and checks that each represents a file on disk
 func (t Task) ManifestsPresent() error {
 	for _, name := range t.Manifests {
-		filename := name + ManifestExtension
-		path := filepath.Join(ManifestRoot, filename)
-		if _, err := os.Stat(path); err != nil {
+		if _, err := ManifestPath(name); err != nil {
 			return err
 		}
 	}
 	if len(t.PodManifest) > 0 {
-		filename := t.PodManifest + ManifestExtension
-		path := filepath.Join(ManifestRoot, filename)
-		if _, err := os.Stat(path); err != nil {
+		if _, err := ManifestPath(t.PodManifest); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// Validate checks for ID, Name, and Tasks on a playbook
+// manifestsIn checks that each of the Manifests and PodManifest items on a
+// task is in manifests
+func (t Task) manifestsIn(manifests *manifest.Registry) error {
+	for _, name := range append(t.Manifests[:len(t.Manifests):len(t.Manifests)], t.PodManifest) {
+		if _, ok := manifests.Get(name); name != "" && !ok {
+			return fmt.Errorf("Task %s uses missing manifest %s", t.Name, name)
+		}
+	}
+	return nil
+}
+
+// Validate checks for ID, Name, and Tasks on a playbook, and that the
+// manifests of its tasks are files in ManifestRoot
 func (p Playbook) Validate() error {
+	return p.validate(Task.ManifestsPresent)
+}
+
+// ValidateWith checks the playbook like Validate, but looks the manifests of
+// its tasks up in manifests instead of ManifestRoot
+func (p Playbook) ValidateWith(manifests *manifest.Registry) error {
+	return p.validate(func(t Task) error { return t.manifestsIn(manifests) })
+}
+
+func (p Playbook) validate(manifestsPresent func(Task) error) error {
 	if len(p.ID) == 0 {
 		return errors.New("Playbook missing required ID")
 	}
+	if p.ID == ErrorsID {
+		return fmt.Errorf("Playbook ID %q is reserved", p.ID)
+	}
 	if len(p.Name) == 0 {
 		return errors.New("Playbook missing required Name")
 	}
 	if len(p.Tasks) == 0 {
 		return errors.New("Playbook requires at least 1 task")
 	}
-	return p.ValidateTasks()
+	if p.MaxParallel < 0 {
+		return errors.New("Playbook max_parallel must not be negative")
+	}
+	if p.OnFailure != "" && p.OnFailure != OnFailureRollback {
+		return fmt.Errorf("Playbook on_failure %q must be rollback", p.OnFailure)
+	}
+	if err := p.validateVars(); err != nil {
+		return err
+	}
+	return p.validateTasks(manifestsPresent)
 }
 
 // ValidateTasks checks a task for fields Name, and one or both of Manifests and
 // PodManifests
 func (p Playbook) ValidateTasks() error {
+	return p.validateTasks(Task.ManifestsPresent)
+}
+
+func (p Playbook) validateTasks(manifestsPresent func(Task) error) error {
 	for _, task := range p.Tasks {
 		if len(task.Name) == 0 {
 			return errors.New("Task missing required Name")
@@ -97,11 +270,29 @@ func (p Playbook) ValidateTasks() error {
 		if len(task.Manifests) == 0 && len(task.PodManifest) == 0 {
 			return errors.New("Task requires at least one manifest or a pod manifest")
 		}
-		if err := task.ManifestsPresent(); err != nil {
+		if len(task.WaitFor) > 0 && len(task.PodManifest) == 0 {
+			return errors.New("Task wait_for requires a pod manifest")
+		}
+		for _, w := range task.WaitFor {
+			if w != WaitForSuccess && w != WaitForFailure && w != WaitForRunning {
+				return fmt.Errorf("Task wait_for %q must be one of success, failure or running", w)
+			}
+		}
+		if task.Retries < 0 {
+			return fmt.Errorf("Task %s retries must not be negative", task.Name)
+		}
+		if task.RetryDelay < 0 || task.Timeout < 0 {
+			return fmt.Errorf("Task %s retry_delay and timeout must not be negative", task.Name)
+		}
+		if _, err := ParseCondition(task.When); err != nil {
+			return fmt.Errorf("Task %s has invalid when condition: %s", task.Name, err)
+		}
+		if err := manifestsPresent(task); err != nil {
 			return err
 		}
 	}
-	return nil
+	_, err := p.Dependencies()
+	return err
 }
 
 // ParsePlaybook unmarshalls a YAML byte sequence into a Playbook struct
@@ -2,12 +2,16 @@ package playbook
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 const MockPlaybookContents string = `---
@@ -149,6 +153,59 @@ func TestParsePlaybook(t *testing.T) {
 	}
 }
 
+func TestParsePlaybookRetries(t *testing.T) {
+	p, err := ParsePlaybook([]byte("tasks:\n  - name: Deploy\n    retries: 3\n    retry_delay: 10s\n    timeout: 2m\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := p.Tasks[0]
+	if task.Retries != 3 || task.RetryDelay != 10*time.Second || task.Timeout != 2*time.Minute {
+		t.Errorf("Expected retries 3, retry_delay 10s and timeout 2m, got %+v", task)
+	}
+
+	encoded, err := json.Marshal(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"name":"Deploy","retries":3,"timeout":"2m0s","retry_delay":"10s"}`
+	if string(encoded) != expected {
+		t.Errorf("Expected JSON %s, got %s", expected, encoded)
+	}
+	var decoded Task
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(task, decoded) {
+		t.Errorf("Expected task %+v from JSON, got %+v", task, decoded)
+	}
+}
+
+func TestParsePlaybookEmptyDependsOn(t *testing.T) {
+	p, err := ParsePlaybook([]byte("tasks:\n  - name: a\n  - name: b\n    depends_on: []\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Tasks[0].DependsOn != nil || p.Tasks[1].DependsOn == nil {
+		t.Errorf("Expected depends_on only on task b, got %+v", p.Tasks)
+	}
+
+	encoded, err := json.Marshal(p.Tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"name":"a"},{"name":"b","depends_on":[]}]`
+	if string(encoded) != expected {
+		t.Errorf("Expected JSON %s, got %s", expected, encoded)
+	}
+	var decoded []Task
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Tasks, decoded) {
+		t.Errorf("Expected tasks %+v from JSON, got %+v", p.Tasks, decoded)
+	}
+}
+
 func TestParsePlaybookMalformed(t *testing.T) {
 	_, err := ParsePlaybook([]byte("asdf"))
 	if err == nil {
@@ -236,6 +293,26 @@ func TestValidatePlaybookFailures(t *testing.T) {
 			},
 			"Playbook requires at least 1 task",
 		},
+		{
+			"Validate Playbook With Unknown On Failure",
+			Playbook{
+				ID:        "playbook id 1",
+				Name:      "playbook 1",
+				Tasks:     []Task{{Name: "task", Manifests: []string{"test-manifest"}}},
+				OnFailure: "retry",
+			},
+			`Playbook on_failure "retry" must be rollback`,
+		},
+		{
+			"Validate Playbook With Reserved Var",
+			Playbook{
+				ID:    "playbook id 1",
+				Name:  "playbook 1",
+				Vars:  []Var{{Name: "version"}, {Name: "broadway"}},
+				Tasks: []Task{{Name: "task", Manifests: []string{"test-manifest"}}},
+			},
+			"Playbook var broadway is reserved for Broadway's own values",
+		},
 		{
 			"Validate Playbook With Tasks Missing Names",
 			Playbook{
@@ -254,6 +331,72 @@ func TestValidatePlaybookFailures(t *testing.T) {
 			},
 			"Task requires at least one manifest or a pod manifest",
 		},
+		{
+			"Validate Playbook With Wait For Without Pod Manifest",
+			Playbook{
+				ID:   "playbook id 1",
+				Name: "playbook 1",
+				Tasks: []Task{{
+					Name:      "task",
+					Manifests: []string{"test-manifest"},
+					WaitFor:   []string{WaitForSuccess},
+				}},
+			},
+			"Task wait_for requires a pod manifest",
+		},
+		{
+			"Validate Playbook With Unknown Wait For",
+			Playbook{
+				ID:   "playbook id 1",
+				Name: "playbook 1",
+				Tasks: []Task{{
+					Name:        "task",
+					PodManifest: "test-manifest",
+					WaitFor:     []string{"done"},
+				}},
+			},
+			`Task wait_for "done" must be one of success, failure or running`,
+		},
+		{
+			"Validate Playbook With Invalid When Condition",
+			Playbook{
+				ID:   "playbook id 1",
+				Name: "playbook 1",
+				Tasks: []Task{{
+					Name:      "task",
+					Manifests: []string{"test-manifest"},
+					When:      "env == ",
+				}},
+			},
+			"Task task has invalid when condition: Expected a value at position 7, found end of expression",
+		},
+		{
+			"Validate Playbook With Negative Retries",
+			Playbook{
+				ID:    "playbook id 1",
+				Name:  "playbook 1",
+				Tasks: []Task{{Name: "task", Manifests: []string{"test-manifest"}, Retries: -1}},
+			},
+			"Task task retries must not be negative",
+		},
+		{
+			"Validate Playbook With Negative Retry Delay",
+			Playbook{
+				ID:    "playbook id 1",
+				Name:  "playbook 1",
+				Tasks: []Task{{Name: "task", Manifests: []string{"test-manifest"}, RetryDelay: -time.Second}},
+			},
+			"Task task retry_delay and timeout must not be negative",
+		},
+		{
+			"Validate Playbook With Reserved ID",
+			Playbook{
+				ID:    "errors",
+				Name:  "playbook 1",
+				Tasks: []Task{{Name: "task", Manifests: []string{"test-manifest"}}},
+			},
+			`Playbook ID "errors" is reserved`,
+		},
 	}
 
 	for _, testcase := range testcases {
@@ -310,6 +453,40 @@ func TestTaskManifestsPresentPasses(t *testing.T) {
 		}
 	}
 }
+func TestPlaybookManifestNames(t *testing.T) {
+	p := Playbook{Tasks: []Task{
+		{Name: "Deploy", Manifests: []string{"web-rc", "web-service"}},
+		{Name: "Migrate", PodManifest: "migrate-pod"},
+		{Name: "Again", Manifests: []string{"web-rc"}, PodManifest: "migrate-pod"},
+	}}
+	expected := []string{"web-rc", "web-service", "migrate-pod"}
+	if names := p.ManifestNames(); fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Errorf("Expected: %v\nActual: %v", expected, names)
+	}
+}
+
+func TestManifestPathFallsBackToJSON(t *testing.T) {
+	jsonPath := filepath.Join(rootDir, "manifests", "test-json-manifest.json")
+	if err := ioutil.WriteFile(jsonPath, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(jsonPath)
+
+	expected := filepath.Join(ManifestRoot, "test-json-manifest.json")
+	path, err := ManifestPath("test-json-manifest")
+	if err != nil || path != expected {
+		t.Errorf("Expected: %s\nActual: %s, %v", expected, path, err)
+	}
+	expected = filepath.Join(ManifestRoot, MockManifestFilename)
+	path, err = ManifestPath("test-manifest")
+	if err != nil || path != expected {
+		t.Errorf("Expected: %s\nActual: %s, %v", expected, path, err)
+	}
+	if _, err := ManifestPath("missing"); !os.IsNotExist(err) {
+		t.Errorf("Expected: File does not exist\nActual: %v", err)
+	}
+}
+
 func TestTaskManifestsPresentFailures(t *testing.T) {
 	testcases := []struct {
 		scenario string
@@ -0,0 +1,373 @@
+package playbook
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/namely/broadway/manifest"
+	"github.com/namely/broadway/store"
+)
+
+// WatchInterval is how often Watch looks for changed files when inotify is
+// not available
+var WatchInterval = 2 * time.Second
+
+// WatchDebounce is how long Watch waits for files to stop changing before
+// reloading, so that an editor saving several files reloads once
+var WatchDebounce = 200 * time.Millisecond
+
+// Catalog is a consistent snapshot of the loaded playbooks, by ID, and the
+// manifests they use. A Catalog is never modified once a Registry hands it
+// out.
+type Catalog struct {
+	Playbooks map[string]Playbook
+	// Manifests are the manifests in ManifestRoot
+	Manifests *manifest.Registry
+	// Versions numbers the uploaded version of each playbook loaded from the
+	// store. Playbooks loaded from files have none.
+	Versions map[string]int
+
+	// uploaded holds the manifests uploaded with each stored playbook
+	uploaded map[string]*manifest.Registry
+}
+
+// newCatalog creates a Catalog of no playbooks
+func newCatalog(manifests *manifest.Registry) *Catalog {
+	return &Catalog{
+		Playbooks: map[string]Playbook{},
+		Manifests: manifests,
+		Versions:  map[string]int{},
+		uploaded:  map[string]*manifest.Registry{},
+	}
+}
+
+// ManifestsFor returns the manifests a playbook is deployed with, by name:
+// those uploaded with it, or else those in ManifestRoot
+func (c *Catalog) ManifestsFor(id string) map[string]*manifest.Manifest {
+	if manifests, ok := c.uploaded[id]; ok {
+		return manifests.Manifests()
+	}
+	return c.Manifests.Manifests()
+}
+
+// setVersion loads version number of playbook p, uploaded with manifests
+func (c *Catalog) setVersion(p Playbook, number int, manifests *manifest.Registry) {
+	c.Playbooks[p.ID] = p
+	c.Versions[p.ID] = number
+	c.uploaded[p.ID] = manifests
+}
+
+// with returns a copy of the Catalog holding version number of playbook p
+func (c *Catalog) with(p Playbook, number int, manifests *manifest.Registry) *Catalog {
+	copied := newCatalog(c.Manifests)
+	for id, pb := range c.Playbooks {
+		copied.Playbooks[id] = pb
+	}
+	for id, n := range c.Versions {
+		copied.Versions[id] = n
+	}
+	for id, m := range c.uploaded {
+		copied.uploaded[id] = m
+	}
+	copied.setVersion(p, number, manifests)
+	return copied
+}
+
+// FileError reports a playbook or manifest file that failed to load. The
+// last good version of the file, if any, stays loaded.
+type FileError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ReloadError lists every file that failed to load in a Reload
+type ReloadError struct {
+	Errors []FileError
+}
+
+func (e ReloadError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for n, err := range e.Errors {
+		messages[n] = fmt.Sprintf("  %s: %s", err.Path, err.Error)
+	}
+	return fmt.Sprintf("Failed to load %d files:\n%s", len(e.Errors), strings.Join(messages, "\n"))
+}
+
+// Registry holds the playbooks of a playbooks directory and the manifests
+// in ManifestRoot, and reloads them when their files change. Playbooks
+// uploaded to its store take the place of files with the same ID.
+type Registry struct {
+	dir   string
+	store store.Store
+	// clusters names the clusters playbooks may target; nil allows any
+	clusters []string
+
+	mu      sync.RWMutex
+	catalog *Catalog
+	errors  []FileError
+
+	// reloadMu serializes reloads; files maps each playbook file to the ID
+	// of the playbook last loaded from it
+	reloadMu sync.Mutex
+	files    map[string]string
+}
+
+// NewRegistry creates a Registry holding playbooks and manifests, that is
+// not backed by files
+func NewRegistry(playbooks []Playbook, manifests *manifest.Registry) *Registry {
+	catalog := newCatalog(manifests)
+	for _, p := range playbooks {
+		catalog.Playbooks[p.ID] = p
+	}
+	return &Registry{catalog: catalog, files: map[string]string{}}
+}
+
+// LoadRegistry loads every playbook in dir and every manifest in
+// ManifestRoot, and the latest version of every playbook uploaded to s, if s
+// is not nil. Playbooks must target one of clusters, unless clusters is nil.
+// Files that fail to load are reported in a ReloadError, and the Registry
+// holds the rest.
+func LoadRegistry(dir string, s store.Store, clusters []string) (*Registry, error) {
+	r := NewRegistry(nil, manifest.NewRegistry())
+	r.dir = dir
+	r.store = s
+	r.clusters = clusters
+	return r, r.Reload()
+}
+
+// CheckCluster returns an error if p targets a cluster the Registry was not
+// loaded with
+func (r *Registry) CheckCluster(p Playbook) error {
+	if p.Cluster == "" || r.clusters == nil {
+		return nil
+	}
+	for _, name := range r.clusters {
+		if name == p.Cluster {
+			return nil
+		}
+	}
+	return fmt.Errorf("Cluster %s is not configured", p.Cluster)
+}
+
+// Catalog returns the current playbooks and manifests
+func (r *Registry) Catalog() *Catalog {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.catalog
+}
+
+// Errors returns the files that failed to load in the last Reload, sorted by
+// path
+func (r *Registry) Errors() []FileError {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.errors
+}
+
+// Reload parses and validates every playbook and manifest file again, and
+// swaps them in at once. A file that fails to load keeps its last good
+// version, if it had one, and is reported in a ReloadError and by Errors.
+func (r *Registry) Reload() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	previous := r.Catalog()
+
+	var errs []FileError
+	manifests, err := manifest.LoadRegistry(ManifestRoot, ManifestExtension, JSONManifestExtension)
+	if loadErr, ok := err.(manifest.LoadError); ok {
+		for _, err := range loadErr.Errors {
+			fileErr, ok := err.(manifest.FileError)
+			if !ok {
+				return err
+			}
+			if m, ok := previous.Manifests.Get(fileErr.Name); ok {
+				manifests.Add(m)
+			}
+			errs = append(errs, FileError{Path: fileErr.Path, Error: fileErr.Err.Error()})
+		}
+	} else if err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(r.dir, "*"))
+	if err != nil {
+		return err
+	}
+	catalog := newCatalog(manifests)
+	files := map[string]string{}
+	sources := map[string]string{}
+	var failed []string
+	for _, path := range paths {
+		p, err := r.loadPlaybook(path, manifests)
+		if err == nil {
+			if other, ok := sources[p.ID]; ok {
+				err = fmt.Errorf("Playbook %s is already loaded from %s", p.ID, other)
+			}
+		}
+		if err != nil {
+			errs = append(errs, FileError{Path: path, Error: err.Error()})
+			failed = append(failed, path)
+			continue
+		}
+		catalog.Playbooks[p.ID] = p
+		files[path] = p.ID
+		sources[p.ID] = path
+	}
+	for _, path := range failed {
+		id, ok := r.files[path]
+		if !ok {
+			continue
+		}
+		if _, taken := catalog.Playbooks[id]; taken {
+			continue
+		}
+		if p, ok := previous.Playbooks[id]; ok {
+			manifests.Reference(p.ID, p.ManifestNames()...)
+			catalog.Playbooks[id] = p
+			files[path] = id
+		}
+	}
+	if r.store != nil {
+		storeErrs, err := r.loadVersions(catalog, previous)
+		if err != nil {
+			return err
+		}
+		errs = append(errs, storeErrs...)
+	}
+	sort.Sort(byPath(errs))
+
+	r.mu.Lock()
+	r.catalog = catalog
+	r.errors = errs
+	r.mu.Unlock()
+	r.files = files
+
+	if len(errs) > 0 {
+		return ReloadError{Errors: errs}
+	}
+	return nil
+}
+
+// loadVersions loads the latest version of every playbook uploaded to the
+// store into catalog. A version that fails to load keeps the version in
+// previous, if any.
+func (r *Registry) loadVersions(catalog, previous *Catalog) ([]FileError, error) {
+	latest, err := LatestVersions(r.store)
+	if err != nil {
+		return nil, err
+	}
+	var errs []FileError
+	for id, number := range latest {
+		v, err := GetVersion(r.store, id, number)
+		if err == nil {
+			var p Playbook
+			var manifests *manifest.Registry
+			if p, manifests, err = v.Load(); err == nil {
+				err = r.CheckCluster(p)
+			}
+			if err == nil {
+				catalog.setVersion(p, number, manifests)
+				continue
+			}
+		}
+		errs = append(errs, FileError{Path: versionKey(id, number), Error: err.Error()})
+		if n, ok := previous.Versions[id]; ok {
+			catalog.setVersion(previous.Playbooks[id], n, previous.uploaded[id])
+		}
+	}
+	return errs, nil
+}
+
+// Put loads an uploaded version of a playbook in place of the one loaded
+// before, if any
+func (r *Registry) Put(v *Version) error {
+	p, manifests, err := v.Load()
+	if err != nil {
+		return err
+	}
+	if err := r.CheckCluster(p); err != nil {
+		return err
+	}
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.catalog = r.catalog.with(p, v.Number, manifests)
+	return nil
+}
+
+// loadPlaybook reads, parses and validates the playbook at path, and
+// references its manifests in manifests
+func (r *Registry) loadPlaybook(path string, manifests *manifest.Registry) (Playbook, error) {
+	content, err := ReadPlaybookFromDisk(path)
+	if err != nil {
+		return Playbook{}, err
+	}
+	p, err := ParsePlaybook(content)
+	if err != nil {
+		return Playbook{}, err
+	}
+	if err := p.Validate(); err != nil {
+		return Playbook{}, err
+	}
+	if err := r.CheckCluster(p); err != nil {
+		return Playbook{}, err
+	}
+	if err := manifests.Reference(p.ID, p.ManifestNames()...); err != nil {
+		return Playbook{}, err
+	}
+	return p, nil
+}
+
+// Watch reloads the Registry whenever a file in its playbooks directory or
+// in ManifestRoot changes, until stop is closed. It is notified by inotify
+// where available, and otherwise checks the files every WatchInterval. The
+// store is checked for uploads by other servers every WatchInterval.
+func (r *Registry) Watch(stop <-chan struct{}) {
+	changes, err := watchInotify(stop, r.dir, ManifestRoot)
+	if err != nil {
+		log.Printf("Polling playbooks for changes every %s: %s\n", WatchInterval, err)
+		changes = watchPoll(stop, WatchInterval, filesFingerprint(r.dir, ManifestRoot))
+	}
+	if r.store != nil {
+		changes = merge(stop, changes, watchPoll(stop, WatchInterval, storeFingerprint(r.store)))
+	}
+	r.watch(stop, changes)
+}
+
+// watch reloads the Registry once changes settle after each notification
+func (r *Registry) watch(stop <-chan struct{}, changes <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-changes:
+		}
+	settle:
+		for {
+			select {
+			case <-stop:
+				return
+			case <-changes:
+			case <-time.After(WatchDebounce):
+				break settle
+			}
+		}
+		if err := r.Reload(); err != nil {
+			log.Printf("Reloaded playbooks with errors: %s\n", err)
+			continue
+		}
+		log.Printf("Reloaded %d playbooks\n", len(r.Catalog().Playbooks))
+	}
+}
+
+type byPath []FileError
+
+func (s byPath) Len() int           { return len(s) }
+func (s byPath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byPath) Less(i, j int) bool { return s[i].Path < s[j].Path }
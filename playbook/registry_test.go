@@ -0,0 +1,266 @@
+package playbook
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/namely/broadway/manifest"
+)
+
+const registryPlaybook = `id: web
+name: %s
+tasks:
+  - name: Deploy
+    manifests:
+      - web
+`
+
+const registryManifest = `apiVersion: v1
+kind: Service
+metadata:
+  name: {{.name}}
+`
+
+// registryFixture creates playbook and manifest directories and points
+// ManifestRoot at the latter, until the returned cleanup runs
+func registryFixture(t *testing.T) (string, func()) {
+	root, err := ioutil.TempDir("", "broadway-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousRoot := ManifestRoot
+	ManifestRoot = filepath.Join(root, "manifests")
+	for _, dir := range []string{"playbooks", "manifests"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(ManifestRoot, "web.yml"), registryManifest)
+	writeFile(t, filepath.Join(root, "playbooks", "web.yml"), playbookNamed("Web"))
+	return filepath.Join(root, "playbooks"), func() {
+		ManifestRoot = previousRoot
+		os.RemoveAll(root)
+	}
+}
+
+func playbookNamed(name string) string {
+	return fmt.Sprintf(registryPlaybook, name)
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func render(t *testing.T, r *Registry, name string) string {
+	m, ok := r.Catalog().Manifests.Get(name)
+	if !ok {
+		t.Fatalf("Manifest %s not loaded", name)
+	}
+	rendered, err := m.Execute(map[string]string{"name": "web"}, manifest.Builtins{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rendered
+}
+
+func TestLoadRegistry(t *testing.T) {
+	dir, cleanup := registryFixture(t)
+	defer cleanup()
+
+	r, err := LoadRegistry(dir, nil, nil)
+	if err != nil {
+		t.Fatalf("LoadRegistry failed: %s", err)
+	}
+	if p, ok := r.Catalog().Playbooks["web"]; !ok || p.Name != "Web" {
+		t.Errorf("Expected playbook web to be loaded, got %+v", r.Catalog().Playbooks)
+	}
+	if users := r.Catalog().Manifests.Users("web"); len(users) != 1 || users[0] != "web" {
+		t.Errorf("Expected manifest web to be used by playbook web, got %v", users)
+	}
+	if len(r.Errors()) != 0 {
+		t.Errorf("Expected no errors, got %v", r.Errors())
+	}
+}
+
+func TestLoadRegistryRejectsUnknownClusters(t *testing.T) {
+	dir, cleanup := registryFixture(t)
+	defer cleanup()
+	writeFile(t, filepath.Join(dir, "api.yml"), "id: api\nname: API\ncluster: prod\ntasks:\n  - name: Deploy\n    manifests:\n      - web\n")
+
+	r, err := LoadRegistry(dir, nil, []string{"staging"})
+	if _, ok := err.(ReloadError); !ok {
+		t.Fatalf("Expected a ReloadError, got %v", err)
+	}
+	if _, ok := r.Catalog().Playbooks["api"]; ok {
+		t.Error("Expected playbook api for an unknown cluster not to be loaded")
+	}
+	if _, ok := r.Catalog().Playbooks["web"]; !ok {
+		t.Error("Expected playbook web to be loaded")
+	}
+	expected := FileError{Path: filepath.Join(dir, "api.yml"), Error: "Cluster prod is not configured"}
+	if errs := r.Errors(); len(errs) != 1 || errs[0] != expected {
+		t.Errorf("Expected errors %v, got %v", []FileError{expected}, errs)
+	}
+
+	if _, err := LoadRegistry(dir, nil, []string{"staging", "prod"}); err != nil {
+		t.Errorf("Expected playbooks for configured clusters to load, got %v", err)
+	}
+}
+
+func TestRegistryReloadKeepsLastGoodPlaybook(t *testing.T) {
+	dir, cleanup := registryFixture(t)
+	defer cleanup()
+	r, err := LoadRegistry(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := r.Catalog()
+
+	path := filepath.Join(dir, "web.yml")
+	writeFile(t, path, playbookNamed(""))
+	err = r.Reload()
+	if _, ok := err.(ReloadError); !ok {
+		t.Fatalf("Expected a ReloadError, got %v", err)
+	}
+	if p := r.Catalog().Playbooks["web"]; p.Name != "Web" {
+		t.Errorf("Expected the last good playbook to stay loaded, got %+v", p)
+	}
+	expected := []FileError{{Path: path, Error: "Playbook missing required Name"}}
+	if errs := r.Errors(); len(errs) != 1 || errs[0] != expected[0] {
+		t.Errorf("Expected errors %v, got %v", expected, errs)
+	}
+	if before.Playbooks["web"].Name != "Web" {
+		t.Error("Reload modified a catalog it had handed out")
+	}
+
+	writeFile(t, path, playbookNamed("Web v2"))
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload failed: %s", err)
+	}
+	if p := r.Catalog().Playbooks["web"]; p.Name != "Web v2" {
+		t.Errorf("Expected the fixed playbook to be loaded, got %+v", p)
+	}
+	if len(r.Errors()) != 0 {
+		t.Errorf("Expected errors to clear, got %v", r.Errors())
+	}
+}
+
+func TestRegistryReloadKeepsLastGoodManifest(t *testing.T) {
+	dir, cleanup := registryFixture(t)
+	defer cleanup()
+	r, err := LoadRegistry(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(ManifestRoot, "web.yml")
+	writeFile(t, path, "kind: {{ .name")
+	if err := r.Reload(); err == nil {
+		t.Fatal("Expected Reload to fail on a broken manifest")
+	}
+	if rendered := render(t, r, "web"); rendered != "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n" {
+		t.Errorf("Expected the last good manifest to stay loaded, got %q", rendered)
+	}
+	if _, ok := r.Catalog().Playbooks["web"]; !ok {
+		t.Error("Expected the playbook using the broken manifest to stay loaded")
+	}
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Path != path {
+		t.Errorf("Expected an error for %s, got %v", path, errs)
+	}
+
+	writeFile(t, path, "kind: Service\nname: {{.name}}-v2\n")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload failed: %s", err)
+	}
+	if rendered := render(t, r, "web"); rendered != "kind: Service\nname: web-v2\n" {
+		t.Errorf("Expected the edited manifest to be loaded, got %q", rendered)
+	}
+}
+
+func TestRegistryReloadRemovedPlaybook(t *testing.T) {
+	dir, cleanup := registryFixture(t)
+	defer cleanup()
+	r, err := LoadRegistry(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "web.yml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload failed: %s", err)
+	}
+	if _, ok := r.Catalog().Playbooks["web"]; ok {
+		t.Error("Expected the removed playbook to be unloaded")
+	}
+}
+
+func TestRegistryReloadDuplicateID(t *testing.T) {
+	dir, cleanup := registryFixture(t)
+	defer cleanup()
+	writeFile(t, filepath.Join(dir, "web-copy.yml"), playbookNamed("Copy"))
+
+	r, err := LoadRegistry(dir, nil, nil)
+	if err == nil {
+		t.Fatal("Expected LoadRegistry to fail on a duplicate playbook ID")
+	}
+	if p := r.Catalog().Playbooks["web"]; p.Name != "Copy" {
+		t.Errorf("Expected the first file to define playbook web, got %+v", p)
+	}
+	expected := FileError{
+		Path:  filepath.Join(dir, "web.yml"),
+		Error: "Playbook web is already loaded from " + filepath.Join(dir, "web-copy.yml"),
+	}
+	if errs := r.Errors(); len(errs) != 1 || errs[0] != expected {
+		t.Errorf("Expected errors %v, got %v", []FileError{expected}, errs)
+	}
+}
+
+func TestRegistryWatch(t *testing.T) {
+	defer func(debounce time.Duration) { WatchDebounce = debounce }(WatchDebounce)
+	WatchDebounce = 10 * time.Millisecond
+
+	testcases := []struct {
+		scenario string
+		changes  func(stop <-chan struct{}, dirs ...string) (<-chan struct{}, error)
+	}{
+		{"inotify", watchInotify},
+		{"polling", func(stop <-chan struct{}, dirs ...string) (<-chan struct{}, error) {
+			return watchPoll(stop, 10*time.Millisecond, filesFingerprint(dirs...)), nil
+		}},
+	}
+	for _, testcase := range testcases {
+		dir, cleanup := registryFixture(t)
+		r, err := LoadRegistry(dir, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stop := make(chan struct{})
+		changes, err := testcase.changes(stop, dir, ManifestRoot)
+		if err != nil {
+			t.Logf("Scenario %s skipped: %s", testcase.scenario, err)
+			close(stop)
+			cleanup()
+			continue
+		}
+		go r.watch(stop, changes)
+
+		writeFile(t, filepath.Join(dir, "web.yml"), playbookNamed("Watched"))
+		deadline := time.Now().Add(5 * time.Second)
+		for r.Catalog().Playbooks["web"].Name != "Watched" && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if p := r.Catalog().Playbooks["web"]; p.Name != "Watched" {
+			t.Errorf("Scenario %s: expected the edited playbook to be reloaded, got %+v", testcase.scenario, p)
+		}
+		close(stop)
+		cleanup()
+	}
+}
@@ -0,0 +1,180 @@
+package playbook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/namely/broadway/manifest"
+	"github.com/namely/broadway/store"
+)
+
+// playbooksPath holds every uploaded version of a playbook, under
+// <id>/<version>
+const playbooksPath = "/broadway/playbooks/"
+
+// latestPath holds the latest version number of each uploaded playbook, by
+// ID, so that uploaded playbooks can be listed
+const latestPath = "/broadway/playbook-versions/"
+
+// Version is one uploaded version of a playbook: its YAML source and the
+// manifests it uses, by name. Versions are numbered from 1 and never
+// removed, so instances can be rolled back to the version they were
+// deployed from.
+type Version struct {
+	ID        string            `json:"id"`
+	Number    int               `json:"version"`
+	Uploaded  string            `json:"uploaded"`
+	User      string            `json:"user,omitempty"`
+	Playbook  string            `json:"playbook,omitempty"`
+	Manifests map[string]string `json:"manifests,omitempty"`
+}
+
+// VersionNotFoundError is returned when a playbook has no uploaded version
+// with a given number
+type VersionNotFoundError struct {
+	ID     string
+	Number int
+}
+
+func (e VersionNotFoundError) Error() string {
+	if e.Number == 0 {
+		return fmt.Sprintf("Playbook %s has no uploaded versions", e.ID)
+	}
+	return fmt.Sprintf("Playbook %s has no version %d", e.ID, e.Number)
+}
+
+func versionKey(id string, number int) string {
+	return playbooksPath + id + "/" + strconv.Itoa(number)
+}
+
+// Load parses and validates the playbook and manifests of v. The playbook
+// must have the ID of v, and the manifests it uses must all be part of v.
+func (v *Version) Load() (Playbook, *manifest.Registry, error) {
+	p, err := ParsePlaybook([]byte(v.Playbook))
+	if err != nil {
+		return Playbook{}, nil, err
+	}
+	manifests := manifest.NewRegistry()
+	for _, name := range sortedNames(v.Manifests) {
+		m, err := manifest.New(name, v.Manifests[name])
+		if err != nil {
+			return Playbook{}, nil, fmt.Errorf("Manifest %s: %s", name, err)
+		}
+		manifests.Add(m)
+	}
+	if p.ID != v.ID {
+		return Playbook{}, nil, fmt.Errorf("Playbook id %s does not match %s", p.ID, v.ID)
+	}
+	if err := p.ValidateWith(manifests); err != nil {
+		return Playbook{}, nil, err
+	}
+	if err := manifests.Reference(p.ID, p.ManifestNames()...); err != nil {
+		return Playbook{}, nil, err
+	}
+	return p, manifests, nil
+}
+
+// SaveVersion stores v as the next version of its playbook, and sets its
+// Number. Each version key is created only if it does not exist, so two
+// servers saving versions of a playbook at once never take the same number.
+func SaveVersion(s store.Store, v *Version) error {
+	latest, err := LatestVersionNumber(s, v.ID)
+	if err != nil {
+		return err
+	}
+	for v.Number = latest + 1; ; v.Number++ {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		err = s.CreateValue(versionKey(v.ID, v.Number), string(encoded))
+		if err == store.ErrExists {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return s.SetValue(latestPath+v.ID, strconv.Itoa(v.Number))
+	}
+}
+
+// GetVersion looks up an uploaded version of a playbook by number
+func GetVersion(s store.Store, id string, number int) (*Version, error) {
+	value := s.Value(versionKey(id, number))
+	if value == "" {
+		return nil, VersionNotFoundError{ID: id, Number: number}
+	}
+	var v Version
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// LatestVersionNumber returns the number of the latest uploaded version of
+// a playbook, or 0 if it was never uploaded
+func LatestVersionNumber(s store.Store, id string) (int, error) {
+	latest := 0
+	if value := s.Value(latestPath + id); value != "" {
+		var err error
+		if latest, err = strconv.Atoi(value); err != nil {
+			return 0, fmt.Errorf("Playbook %s has invalid latest version %q", id, value)
+		}
+	}
+	return pastLatest(s, id, latest), nil
+}
+
+// pastLatest returns the number of the last version of a playbook from
+// latest on. The latest number stored may lag behind a version saved at the
+// same time by another server.
+func pastLatest(s store.Store, id string, latest int) int {
+	for s.Value(versionKey(id, latest+1)) != "" {
+		latest++
+	}
+	return latest
+}
+
+// LatestVersions returns the latest uploaded version number of every
+// uploaded playbook, by ID
+func LatestVersions(s store.Store) (map[string]int, error) {
+	latest := map[string]int{}
+	for id, value := range s.Values(latestPath) {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("Playbook %s has invalid latest version %q", id, value)
+		}
+		latest[id] = pastLatest(s, id, number)
+	}
+	return latest, nil
+}
+
+// Versions returns every uploaded version of a playbook, oldest first
+func Versions(s store.Store, id string) ([]Version, error) {
+	versions := []Version{}
+	for _, value := range s.Values(playbooksPath + id) {
+		var v Version
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	sort.Sort(versionsByNumber(versions))
+	return versions, nil
+}
+
+type versionsByNumber []Version
+
+func (v versionsByNumber) Len() int           { return len(v) }
+func (v versionsByNumber) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v versionsByNumber) Less(i, j int) bool { return v[i].Number < v[j].Number }
+
+func sortedNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
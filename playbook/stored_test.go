@@ -0,0 +1,193 @@
+package playbook
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/namely/broadway/manifest"
+	"github.com/namely/broadway/store"
+)
+
+func uploadedVersion(name string) *Version {
+	return &Version{
+		ID:        "web",
+		Playbook:  playbookNamed(name),
+		Manifests: map[string]string{"web": registryManifest},
+	}
+}
+
+func TestSaveVersion(t *testing.T) {
+	s := store.NewMemory()
+	for n := 1; n <= 2; n++ {
+		v := uploadedVersion("Web")
+		if err := SaveVersion(s, v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Number != n {
+			t.Errorf("Expected version %d, got %d", n, v.Number)
+		}
+	}
+
+	latest, err := LatestVersions(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 1 || latest["web"] != 2 {
+		t.Errorf("Expected latest version 2 of web, got %v", latest)
+	}
+	versions, err := Versions(s, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Number != 1 || versions[1].Number != 2 {
+		t.Errorf("Expected versions 1 and 2, got %+v", versions)
+	}
+	v, err := GetVersion(s, "web", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Playbook != playbookNamed("Web") || v.Manifests["web"] != registryManifest {
+		t.Errorf("Expected version 1 to hold the uploaded files, got %+v", v)
+	}
+	if _, err := GetVersion(s, "web", 3); err != (VersionNotFoundError{ID: "web", Number: 3}) {
+		t.Errorf("Expected VersionNotFoundError, got %v", err)
+	}
+}
+
+func TestSaveVersionSkipsTakenNumbers(t *testing.T) {
+	s := store.NewMemory()
+	if err := SaveVersion(s, uploadedVersion("Web")); err != nil {
+		t.Fatal(err)
+	}
+	// Another server saved version 2 but has not recorded it as latest yet
+	if err := s.CreateValue(versionKey("web", 2), `{"id":"web","version":2}`); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := LatestVersionNumber(s, "web"); err != nil || n != 2 {
+		t.Errorf("Expected latest version 2, got %d, %v", n, err)
+	}
+	v := uploadedVersion("Web")
+	if err := SaveVersion(s, v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Number != 3 {
+		t.Errorf("Expected version 3, got %d", v.Number)
+	}
+	other, err := GetVersion(s, "web", 2)
+	if err != nil || other.Playbook != "" {
+		t.Errorf("Expected version 2 to be kept, got %+v, %v", other, err)
+	}
+}
+
+func TestSaveVersionConcurrently(t *testing.T) {
+	s := store.NewMemory()
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := SaveVersion(s, uploadedVersion("Web")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	versions, err := Versions(s, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 10 {
+		t.Errorf("Expected 10 versions, got %d", len(versions))
+	}
+	if latest, _ := LatestVersions(s); latest["web"] != 10 {
+		t.Errorf("Expected latest version 10, got %v", latest)
+	}
+}
+
+func TestVersionLoad(t *testing.T) {
+	p, manifests, err := uploadedVersion("Web").Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Web" {
+		t.Errorf("Expected playbook Web, got %+v", p)
+	}
+	if _, ok := manifests.Get("web"); !ok {
+		t.Error("Expected manifest web to be loaded")
+	}
+
+	testcases := []struct {
+		scenario    string
+		version     *Version
+		expectedErr string
+	}{
+		{
+			"ID mismatch",
+			&Version{ID: "api", Playbook: playbookNamed("Web"), Manifests: map[string]string{"web": registryManifest}},
+			"Playbook id web does not match api",
+		},
+		{
+			"Missing manifest",
+			&Version{ID: "web", Playbook: playbookNamed("Web")},
+			"Task Deploy uses missing manifest web",
+		},
+		{
+			"Broken manifest",
+			&Version{ID: "web", Playbook: playbookNamed("Web"), Manifests: map[string]string{"web": "{{ .name"}},
+			"Manifest web: template: web:1: unclosed action",
+		},
+		{
+			"Invalid playbook",
+			&Version{ID: "web", Playbook: playbookNamed(""), Manifests: map[string]string{"web": registryManifest}},
+			"Playbook missing required Name",
+		},
+	}
+	for _, testcase := range testcases {
+		_, _, err := testcase.version.Load()
+		if err == nil || err.Error() != testcase.expectedErr {
+			t.Errorf("Scenario %s\nExpected:\n%s\nActual:\n%v", testcase.scenario, testcase.expectedErr, err)
+		}
+	}
+}
+
+func TestRegistryLoadsUploadedPlaybooks(t *testing.T) {
+	dir, cleanup := registryFixture(t)
+	defer cleanup()
+	s := store.NewMemory()
+	v := uploadedVersion("Uploaded")
+	v.Manifests["web"] = "kind: Uploaded\n"
+	if err := SaveVersion(s, v); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := LoadRegistry(dir, s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog := r.Catalog()
+	if p := catalog.Playbooks["web"]; p.Name != "Uploaded" {
+		t.Errorf("Expected the uploaded playbook to replace the file, got %+v", p)
+	}
+	if n := catalog.Versions["web"]; n != 1 {
+		t.Errorf("Expected version 1, got %d", n)
+	}
+	if m := catalog.ManifestsFor("web")["web"]; m == nil {
+		t.Error("Expected the uploaded manifest to be used")
+	} else if rendered, _ := m.Execute(nil, manifest.Builtins{}); rendered != "kind: Uploaded\n" {
+		t.Errorf("Expected the uploaded manifest to be used, got %q", rendered)
+	}
+
+	next := uploadedVersion("Uploaded v2")
+	if err := SaveVersion(s, next); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Put(next); err != nil {
+		t.Fatal(err)
+	}
+	if p := r.Catalog().Playbooks["web"]; p.Name != "Uploaded v2" || r.Catalog().Versions["web"] != 2 {
+		t.Errorf("Expected version 2 to be loaded, got %+v", p)
+	}
+	if catalog.Playbooks["web"].Name != "Uploaded" {
+		t.Error("Put modified a catalog it had handed out")
+	}
+}
@@ -0,0 +1,190 @@
+package playbook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/namely/broadway/manifest"
+)
+
+// Var declares a var that instances of a playbook may set. In a playbook, a
+// var is either just its name or a mapping:
+//
+//	vars:
+//	  - version
+//	  - name: replicas
+//	    description: Pods to run
+//	    default: "1"
+//	    pattern: "[0-9]+"
+//	  - name: tier
+//	    required: true
+//	    enum: [web, worker]
+type Var struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	// Required vars must be set to a non-empty value by every instance
+	Required bool `yaml:"required,omitempty" json:"required,omitempty"`
+	// Default is used when an instance leaves the var unset or empty
+	Default string `yaml:"default,omitempty" json:"default,omitempty"`
+	// Pattern is a regular expression the whole value must match
+	Pattern string   `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+	Enum    []string `yaml:"enum,omitempty" json:"enum,omitempty"`
+}
+
+// plainVar has the fields of Var without its marshalling methods
+type plainVar Var
+
+// UnmarshalYAML reads a var from its name or from a mapping
+func (v *Var) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*v = Var{Name: name}
+		return nil
+	}
+	return unmarshal((*plainVar)(v))
+}
+
+// UnmarshalJSON reads a var from its name or from an object
+func (v *Var) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*v = Var{Name: name}
+		return nil
+	}
+	return json.Unmarshal(data, (*plainVar)(v))
+}
+
+// MarshalJSON writes a var that only has a name as that name, as playbooks
+// with a list of var names were always served
+func (v Var) MarshalJSON() ([]byte, error) {
+	if v.isNameOnly() {
+		return json.Marshal(v.Name)
+	}
+	return json.Marshal(plainVar(v))
+}
+
+func (v Var) isNameOnly() bool {
+	return v.Description == "" && !v.Required && v.Default == "" && v.Pattern == "" && len(v.Enum) == 0
+}
+
+// VarsError lists every way the vars of an instance break the var
+// declarations of its playbook
+type VarsError struct {
+	Violations []string
+}
+
+func (e VarsError) Error() string {
+	return "Invalid vars: " + strings.Join(e.Violations, "; ")
+}
+
+// validateVars checks the var declarations of the playbook
+func (p Playbook) validateVars() error {
+	declared := map[string]bool{}
+	for _, v := range p.Vars {
+		if v.Name == "" {
+			return errors.New("Playbook var missing required name")
+		}
+		if v.Name == manifest.BuiltinsKey {
+			return fmt.Errorf("Playbook var %s is reserved for Broadway's own values", v.Name)
+		}
+		if declared[v.Name] {
+			return fmt.Errorf("Playbook var %s is declared twice", v.Name)
+		}
+		declared[v.Name] = true
+		if _, err := v.pattern(); err != nil {
+			return fmt.Errorf("Playbook var %s has invalid pattern: %s", v.Name, err)
+		}
+		if v.Required && v.Default != "" {
+			return fmt.Errorf("Playbook var %s cannot be required and have a default", v.Name)
+		}
+		if v.Default != "" {
+			if err := v.check(v.Default); err != "" {
+				return fmt.Errorf("Playbook var %s default: %s", v.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// pattern compiles the var's pattern to match whole values, or returns nil
+// if it has none
+func (v Var) pattern() (*regexp.Regexp, error) {
+	if v.Pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + v.Pattern + ")$")
+}
+
+// check returns why value is not allowed for the var, or "" if it is
+func (v Var) check(value string) string {
+	if len(v.Enum) > 0 && !contains(v.Enum, value) {
+		return fmt.Sprintf("var %s must be one of %s", v.Name, strings.Join(v.Enum, ", "))
+	}
+	if re, err := v.pattern(); err == nil && re != nil && !re.MatchString(value) {
+		return fmt.Sprintf("var %s must match %s", v.Name, v.Pattern)
+	}
+	return ""
+}
+
+// CheckVars checks the vars of an instance against the var declarations of
+// the playbook, and returns a VarsError listing every var that is not
+// declared, required but empty, or not allowed by its enum or pattern
+func (p Playbook) CheckVars(vars map[string]string) error {
+	var violations []string
+	declared := map[string]bool{}
+	for _, v := range p.Vars {
+		declared[v.Name] = true
+		value := vars[v.Name]
+		if value == "" {
+			if v.Required {
+				violations = append(violations, fmt.Sprintf("var %s is required", v.Name))
+			}
+			continue
+		}
+		if err := v.check(value); err != "" {
+			violations = append(violations, err)
+		}
+	}
+	var undeclared []string
+	for name := range vars {
+		if !declared[name] {
+			undeclared = append(undeclared, name)
+		}
+	}
+	sort.Strings(undeclared)
+	for _, name := range undeclared {
+		violations = append(violations, fmt.Sprintf("var %s is not declared by playbook %s", name, p.ID))
+	}
+	if len(violations) > 0 {
+		return VarsError{Violations: violations}
+	}
+	return nil
+}
+
+// WithDefaults returns a copy of vars with the default of every declared var
+// that vars leaves unset or empty
+func (p Playbook) WithDefaults(vars map[string]string) map[string]string {
+	filled := make(map[string]string, len(vars))
+	for k, v := range vars {
+		filled[k] = v
+	}
+	for _, v := range p.Vars {
+		if filled[v.Name] == "" && v.Default != "" {
+			filled[v.Name] = v.Default
+		}
+	}
+	return filled
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
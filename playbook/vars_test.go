@@ -0,0 +1,127 @@
+package playbook
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const varsPlaybook = `id: web
+name: Web
+vars:
+  - version
+  - name: replicas
+    description: Pods to run
+    default: "1"
+    pattern: "[0-9]+"
+  - name: tier
+    required: true
+    enum: [web, worker]
+tasks:
+  - name: Deploy
+    manifests:
+      - test-manifest
+`
+
+var varsPlaybookVars = []Var{
+	{Name: "version"},
+	{Name: "replicas", Description: "Pods to run", Default: "1", Pattern: "[0-9]+"},
+	{Name: "tier", Required: true, Enum: []string{"web", "worker"}},
+}
+
+func TestParsePlaybookVars(t *testing.T) {
+	p, err := ParsePlaybook([]byte(varsPlaybook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(varsPlaybookVars, p.Vars) {
+		t.Errorf("Expected vars %+v, got %+v", varsPlaybookVars, p.Vars)
+	}
+	if err := p.Validate(); err != nil {
+		t.Errorf("Expected playbook to be valid, got %s", err)
+	}
+
+	encoded, err := json.Marshal(p.Vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `["version",{"name":"replicas","description":"Pods to run","default":"1","pattern":"[0-9]+"},{"name":"tier","required":true,"enum":["web","worker"]}]`
+	if string(encoded) != expected {
+		t.Errorf("Expected JSON %s, got %s", expected, encoded)
+	}
+	var decoded []Var
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(varsPlaybookVars, decoded) {
+		t.Errorf("Expected vars %+v from JSON, got %+v", varsPlaybookVars, decoded)
+	}
+}
+
+func TestValidatePlaybookVarsFailures(t *testing.T) {
+	testcases := []struct {
+		scenario    string
+		vars        []Var
+		expectedErr string
+	}{
+		{"Missing name", []Var{{Default: "1"}}, "Playbook var missing required name"},
+		{"Declared twice", []Var{{Name: "version"}, {Name: "version"}}, "Playbook var version is declared twice"},
+		{"Invalid pattern", []Var{{Name: "version", Pattern: "("}}, "Playbook var version has invalid pattern: error parsing regexp: missing closing ): `^(?:()$`"},
+		{"Required with default", []Var{{Name: "version", Required: true, Default: "1"}}, "Playbook var version cannot be required and have a default"},
+		{"Default outside enum", []Var{{Name: "tier", Default: "db", Enum: []string{"web"}}}, "Playbook var tier default: var tier must be one of web"},
+		{"Default not matching pattern", []Var{{Name: "replicas", Default: "one", Pattern: "[0-9]+"}}, "Playbook var replicas default: var replicas must match [0-9]+"},
+	}
+	for _, testcase := range testcases {
+		p := Playbook{
+			ID:    "web",
+			Name:  "Web",
+			Vars:  testcase.vars,
+			Tasks: []Task{{Name: "Deploy", Manifests: []string{"test-manifest"}}},
+		}
+		err := p.Validate()
+		if err == nil || err.Error() != testcase.expectedErr {
+			t.Errorf("Scenario %s\nExpected:\n%s\nActual:\n%v", testcase.scenario, testcase.expectedErr, err)
+		}
+	}
+}
+
+func TestCheckVars(t *testing.T) {
+	p := Playbook{ID: "web", Vars: varsPlaybookVars}
+	if err := p.CheckVars(map[string]string{"tier": "web", "replicas": "3"}); err != nil {
+		t.Errorf("Expected vars to be valid, got %s", err)
+	}
+
+	err := p.CheckVars(map[string]string{"replicas": "three", "owner": "jane", "color": "blue"})
+	expected := VarsError{Violations: []string{
+		"var replicas must match [0-9]+",
+		"var tier is required",
+		"var color is not declared by playbook web",
+		"var owner is not declared by playbook web",
+	}}
+	if !reflect.DeepEqual(expected, err) {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+
+	err = p.CheckVars(map[string]string{"tier": "db"})
+	expected = VarsError{Violations: []string{"var tier must be one of web, worker"}}
+	if !reflect.DeepEqual(expected, err) {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	p := Playbook{ID: "web", Vars: varsPlaybookVars}
+	vars := map[string]string{"tier": "web", "replicas": ""}
+	filled := p.WithDefaults(vars)
+	expected := map[string]string{"tier": "web", "replicas": "1"}
+	if !reflect.DeepEqual(expected, filled) {
+		t.Errorf("Expected %v, got %v", expected, filled)
+	}
+	if vars["replicas"] != "" {
+		t.Error("WithDefaults modified the vars it was given")
+	}
+	filled = p.WithDefaults(map[string]string{"replicas": "3"})
+	if filled["replicas"] != "3" {
+		t.Errorf("Expected a set var to keep its value, got %s", filled["replicas"])
+	}
+}
@@ -0,0 +1,102 @@
+package playbook
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/namely/broadway/store"
+)
+
+// notify signals changes without blocking, coalescing signals that were not
+// received yet
+func notify(changes chan struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+// merge signals on the returned channel whenever a or b signals, until stop
+// is closed
+func merge(stop <-chan struct{}, a, b <-chan struct{}) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-a:
+			case <-b:
+			}
+			notify(changes)
+		}
+	}()
+	return changes
+}
+
+// watchPoll signals on the returned channel whenever fingerprint changes,
+// checking every interval until stop is closed
+func watchPoll(stop <-chan struct{}, interval time.Duration, fingerprint func() string) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	last := fingerprint()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if current := fingerprint(); current != last {
+				last = current
+				notify(changes)
+			}
+		}
+	}()
+	return changes
+}
+
+// filesFingerprint describes the path, size and modification time of every
+// file under dirs
+func filesFingerprint(dirs ...string) func() string {
+	return func() string {
+		var b bytes.Buffer
+		for _, dir := range dirs {
+			filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					fmt.Fprintf(&b, "%s: %s\n", path, err)
+					return nil
+				}
+				fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+				return nil
+			})
+		}
+		return b.String()
+	}
+}
+
+// storeFingerprint describes the latest version of every playbook uploaded
+// to s
+func storeFingerprint(s store.Store) func() string {
+	return func() string {
+		latest, err := LatestVersions(s)
+		if err != nil {
+			return err.Error()
+		}
+		ids := make([]string, 0, len(latest))
+		for id := range latest {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		var b bytes.Buffer
+		for _, id := range ids {
+			fmt.Fprintf(&b, "%s %d\n", id, latest[id])
+		}
+		return b.String()
+	}
+}
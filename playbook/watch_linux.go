@@ -0,0 +1,92 @@
+//go:build linux
+// +build linux
+
+package playbook
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// inotifyEvents are the events that make a watched directory change
+const inotifyEvents = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_ATTRIB | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotifyWatcher guards an inotify descriptor, so that no watch is added
+// once it is closed and its number may belong to another file
+type inotifyWatcher struct {
+	mu     sync.Mutex
+	fd     int
+	file   *os.File
+	closed bool
+}
+
+// watchInotify signals on the returned channel whenever inotify reports a
+// change under dirs, until stop is closed
+func watchInotify(stop <-chan struct{}, dirs ...string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// A non-blocking descriptor is read through the runtime poller, so that
+	// closing the file ends a pending Read
+	w := &inotifyWatcher{fd: fd, file: os.NewFile(uintptr(fd), "inotify")}
+	if err := w.addWatches(dirs); err != nil {
+		w.close()
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		<-stop
+		w.close()
+	}()
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			if _, err := w.file.Read(buf); err != nil {
+				return
+			}
+			// Watch directories created since, e.g. manifests/web/
+			w.addWatches(dirs)
+			notify(changes)
+		}
+	}()
+	return changes, nil
+}
+
+// close closes the descriptor. Watches are no longer added after it.
+func (w *inotifyWatcher) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		w.file.Close()
+	}
+}
+
+// addWatches watches every directory under dirs. Directories already watched
+// keep their watch.
+func (w *inotifyWatcher) addWatches(dirs []string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return err
+			}
+			_, err = syscall.InotifyAddWatch(w.fd, path, inotifyEvents)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package playbook
+
+import "errors"
+
+// watchInotify is only available on Linux; elsewhere Watch polls
+func watchInotify(stop <-chan struct{}, dirs ...string) (<-chan struct{}, error) {
+	return nil, errors.New("inotify is not available on this platform")
+}
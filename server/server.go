@@ -1,12 +1,22 @@
 package server
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/namely/broadway/broadway"
+	"github.com/namely/broadway/deployment"
 	"github.com/namely/broadway/instance"
+	"github.com/namely/broadway/manifest"
+	"github.com/namely/broadway/playbook"
 	"github.com/namely/broadway/services"
 	"github.com/namely/broadway/store"
 
@@ -17,14 +27,22 @@ import (
 // Server provides an HTTP interface to manipulate Playbooks and Instances
 type Server struct {
 	store      store.Store
+	clusters   *deployment.Clusters
 	slackToken string
 	engine     *gin.Engine
+	cleanup    services.Cleanup
+	queue      *deployment.Queue
+	playbooks  *playbook.Registry
 }
 
 // slackTokenENV is the name of an environment variable. Set the value to match
 // Slack's given custom command token.
 const slackTokenENV string = "SLACK_VERIFICATION_TOKEN"
 
+// userHeader is the request header API callers name themselves in, for the
+// revision history
+const userHeader string = "X-Broadway-User"
+
 // ErrorResponse represents a JSON response to be returned in failure cases
 type ErrorResponse map[string]string
 
@@ -40,27 +58,56 @@ var NotFoundError = ErrorResponse{"error": "Not Found"}
 // InternalError represents a JSON response for status 500
 var InternalError = map[string]string{"error": "Internal Server Error"}
 
+// VarsErrorResponse represents a JSON response for status 400 listing every
+// invalid var of an instance
+type VarsErrorResponse struct {
+	Error      string   `json:"error"`
+	Violations []string `json:"violations"`
+}
+
 // CustomError creates an ErrorResponse with a custom message
 func CustomError(message string) ErrorResponse {
 	return ErrorResponse{"error": message}
 }
 
 // New instantiates a new Server and binds its handlers. The Server will look
-// for playbooks and instances in store `s`
-func New(s store.Store) *Server {
+// for playbooks and instances in store `s`, and remove deleted instances from
+// the cluster in `clusters` they were deployed to
+func New(s store.Store, clusters *deployment.Clusters) *Server {
 	srvr := &Server{
 		store:      s,
+		clusters:   clusters,
 		slackToken: os.Getenv(slackTokenENV),
+		queue:      deployment.NewQueue(s),
+		playbooks:  playbook.NewRegistry(nil, manifest.NewRegistry()),
 	}
+	srvr.cleanup = srvr.teardownInstance
 	srvr.setupHandlers()
 	return srvr
 }
 
+// SetPlaybooks gives the Server the registry of playbooks that instances may
+// be created for, and of the manifests they are planned with
+func (s *Server) SetPlaybooks(playbooks *playbook.Registry) {
+	s.playbooks = playbooks
+}
+
 func (s *Server) setupHandlers() {
 	s.engine = gin.Default()
 	gin.SetMode(gin.ReleaseMode) // Comment this to use debug mode for more verbose output
+	s.engine.GET("/playbooks", s.getPlaybooks)
+	s.engine.GET("/playbooks/:playbookID", s.getPlaybook)
+	s.engine.PUT("/playbooks/:playbookID", s.putPlaybook)
+	s.engine.GET("/playbooks/:playbookID/instances", s.getPlaybookInstances)
+	s.engine.GET("/playbooks/:playbookID/versions", s.getPlaybookVersions)
+	s.engine.GET("/playbooks/:playbookID/versions/:version", s.getPlaybookVersion)
 	s.engine.POST("/instances", s.createInstance)
 	s.engine.GET("/instance/:playbookID/:instanceID", s.getInstance)
+	s.engine.DELETE("/instance/:playbookID/:instanceID", s.deleteInstance)
+	s.engine.POST("/instance/:playbookID/:instanceID/deploy", s.deployInstance)
+	s.engine.POST("/instance/:playbookID/:instanceID/plan", s.planInstance)
+	s.engine.GET("/instance/:playbookID/:instanceID/revisions", s.getRevisions)
+	s.engine.POST("/instance/:playbookID/:instanceID/rollback/:revision", s.rollbackInstance)
 	s.engine.GET("/instances/:playbookID", s.getInstances)
 	s.engine.GET("/status", s.getStatus400)
 	s.engine.GET("/status/:playbookID", s.getStatus400)
@@ -79,24 +126,354 @@ func (s *Server) Run(addr ...string) error {
 	return s.engine.Run(addr...)
 }
 
+func (s *Server) getPlaybooks(c *gin.Context) {
+	catalog := s.playbooks.Catalog()
+	ids := make([]string, 0, len(catalog.Playbooks))
+	for id := range catalog.Playbooks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	playbooks := make([]playbook.Playbook, len(ids))
+	for n, id := range ids {
+		playbooks[n] = catalog.Playbooks[id]
+	}
+	c.JSON(http.StatusOK, playbooks)
+}
+
+// getPlaybook serves a playbook, or GET /playbooks/errors, which gin cannot
+// route next to /playbooks/:playbookID. No playbook may use the ID "errors".
+func (s *Server) getPlaybook(c *gin.Context) {
+	if c.Param("playbookID") == playbook.ErrorsID {
+		s.getPlaybookErrors(c)
+		return
+	}
+	pb, ok := s.playbooks.Catalog().Playbooks[c.Param("playbookID")]
+	if !ok {
+		c.JSON(http.StatusNotFound, NotFoundError)
+		return
+	}
+	c.JSON(http.StatusOK, pb)
+}
+
+// getPlaybookErrors lists the playbook and manifest files that failed to
+// load, and are served at their last good version if they had one
+func (s *Server) getPlaybookErrors(c *gin.Context) {
+	errs := s.playbooks.Errors()
+	if errs == nil {
+		errs = []playbook.FileError{}
+	}
+	c.JSON(http.StatusOK, errs)
+}
+
+// uploadRequest is the body of a playbook upload: the playbook YAML and the
+// manifests it uses, by name
+type uploadRequest struct {
+	Playbook  string            `json:"playbook" binding:"required"`
+	Manifests map[string]string `json:"manifests"`
+}
+
+// putPlaybook validates an uploaded playbook and stores it as its next
+// version, which is loaded at once
+func (s *Server) putPlaybook(c *gin.Context) {
+	var r uploadRequest
+	if err := c.BindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, CustomError("Missing: "+err.Error()))
+		return
+	}
+	v := &playbook.Version{
+		ID:        c.Param("playbookID"),
+		Uploaded:  time.Now().UTC().Format(time.RFC3339),
+		User:      requestUser(c),
+		Playbook:  r.Playbook,
+		Manifests: r.Manifests,
+	}
+	p, _, err := v.Load()
+	if err == nil && p.Cluster != "" {
+		_, err = s.clusters.Client(p.Cluster)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, CustomError("Invalid playbook: "+err.Error()))
+		return
+	}
+	if err := playbook.SaveVersion(s.store, v); err != nil {
+		c.JSON(http.StatusInternalServerError, InternalError)
+		return
+	}
+	if err := s.playbooks.Put(v); err != nil {
+		c.JSON(http.StatusInternalServerError, InternalError)
+		return
+	}
+	c.JSON(http.StatusCreated, versionSummary(*v))
+}
+
+// versionSummary leaves the playbook and manifests out of v
+func versionSummary(v playbook.Version) playbook.Version {
+	v.Playbook = ""
+	v.Manifests = nil
+	return v
+}
+
+func (s *Server) getPlaybookVersions(c *gin.Context) {
+	versions, err := playbook.Versions(s.store, c.Param("playbookID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, InternalError)
+		return
+	}
+	if len(versions) == 0 {
+		c.JSON(http.StatusNotFound, NotFoundError)
+		return
+	}
+	for n, v := range versions {
+		versions[n] = versionSummary(v)
+	}
+	c.JSON(http.StatusOK, versions)
+}
+
+func (s *Server) getPlaybookVersion(c *gin.Context) {
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, CustomError("Version must be a number"))
+		return
+	}
+	v, err := playbook.GetVersion(s.store, c.Param("playbookID"), number)
+	if err != nil {
+		switch err.(type) {
+		case playbook.VersionNotFoundError:
+			c.JSON(http.StatusNotFound, NotFoundError)
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, InternalError)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) getPlaybookInstances(c *gin.Context) {
+	if _, ok := s.playbooks.Catalog().Playbooks[c.Param("playbookID")]; !ok {
+		c.JSON(http.StatusNotFound, NotFoundError)
+		return
+	}
+	instances, err := instance.List(s.store, c.Param("playbookID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, InternalError)
+		return
+	}
+	c.JSON(http.StatusOK, instances)
+}
+
 func (s *Server) createInstance(c *gin.Context) {
 	var i broadway.Instance
 	if err := c.BindJSON(&i); err != nil {
 		c.JSON(http.StatusBadRequest, CustomError("Missing: "+err.Error()))
 		return
 	}
+	pb, ok := s.playbooks.Catalog().Playbooks[i.PlaybookID]
+	if !ok {
+		c.JSON(http.StatusBadRequest, CustomError("Unknown playbook: "+i.PlaybookID))
+		return
+	}
+	if i.Cluster != "" {
+		if _, err := s.clusters.Client(i.Cluster); err != nil {
+			c.JSON(http.StatusBadRequest, CustomError("Unknown cluster: "+i.Cluster))
+			return
+		}
+	}
+	if err := pb.CheckVars(i.Vars); err != nil {
+		c.JSON(http.StatusBadRequest, VarsErrorResponse{
+			Error:      "Invalid vars",
+			Violations: err.(playbook.VarsError).Violations,
+		})
+		return
+	}
 
-	service := services.NewInstanceService(store.New())
+	service := services.NewInstanceService(s.store)
 	err := service.Create(i)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, InternalError)
 		return
 	}
+	if stored, err := service.Show(i.PlaybookID, i.ID); err == nil {
+		i = stored
+	}
+
+	if err := s.queue.PushRequest(deployment.Request{
+		PlaybookID: i.PlaybookID,
+		InstanceID: i.ID,
+		User:       requestUser(c),
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, InternalError)
+		return
+	}
 
 	c.JSON(http.StatusCreated, i)
 }
 
+func (s *Server) deployInstance(c *gin.Context) {
+	service := services.NewInstanceService(s.store)
+	i, err := service.Show(c.Param("playbookID"), c.Param("instanceID"))
+
+	if err != nil {
+		switch err.(type) {
+		case broadway.InstanceNotFoundError:
+			c.JSON(http.StatusNotFound, NotFoundError)
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, InternalError)
+			return
+		}
+	}
+
+	if err := s.queue.PushRequest(deployment.Request{
+		PlaybookID: i.PlaybookID,
+		InstanceID: i.ID,
+		User:       requestUser(c),
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, InternalError)
+		return
+	}
+	c.JSON(http.StatusAccepted, map[string]string{
+		"status": "queued",
+	})
+}
+
+// planRequest is the optional body of a plan request. Vars override the
+// instance's vars.
+type planRequest struct {
+	Vars map[string]string `json:"vars"`
+}
+
+func (s *Server) planInstance(c *gin.Context) {
+	var r planRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&r); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, CustomError("Invalid plan request: "+err.Error()))
+		return
+	}
+
+	i, err := instance.Get(s.store, c.Param("playbookID"), c.Param("instanceID"))
+	if err != nil {
+		switch err.(type) {
+		case instance.NotFoundError:
+			c.JSON(http.StatusNotFound, NotFoundError)
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, InternalError)
+			return
+		}
+	}
+	catalog := s.playbooks.Catalog()
+	pb, ok := catalog.Playbooks[i.PlaybookID()]
+	if !ok {
+		c.JSON(http.StatusNotFound, CustomError("Playbook "+i.PlaybookID()+" not found"))
+		return
+	}
+	vars := map[string]string{}
+	for k, v := range i.Attributes().Vars {
+		vars[k] = v
+	}
+	for k, v := range r.Vars {
+		vars[k] = v
+	}
+	if err := pb.CheckVars(vars); err != nil {
+		c.JSON(http.StatusBadRequest, VarsErrorResponse{
+			Error:      "Invalid vars",
+			Violations: err.(playbook.VarsError).Violations,
+		})
+		return
+	}
+	client, err := s.clusters.Client(s.clusters.Resolve(i.Attributes().Cluster, pb.Cluster))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, CustomError(err.Error()))
+		return
+	}
+
+	d := &deployment.Deployment{
+		Client:    client,
+		Playbook:  pb,
+		Manifests: catalog.ManifestsFor(pb.ID),
+		User:      requestUser(c),
+	}
+	plan, err := d.PlanInstance(i, r.Vars)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, CustomError("Failed to plan instance: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+func (s *Server) getRevisions(c *gin.Context) {
+	service := services.NewInstanceService(s.store)
+	i, err := service.Show(c.Param("playbookID"), c.Param("instanceID"))
+	if err != nil {
+		switch err.(type) {
+		case broadway.InstanceNotFoundError:
+			c.JSON(http.StatusNotFound, NotFoundError)
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, InternalError)
+			return
+		}
+	}
+
+	revisions, err := deployment.Revisions(s.store, i.PlaybookID, i.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, InternalError)
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+func (s *Server) rollbackInstance(c *gin.Context) {
+	revision, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, CustomError("Revision must be a number"))
+		return
+	}
+
+	err = s.queueRollback(c.Param("playbookID"), c.Param("instanceID"), revision, requestUser(c))
+	if err != nil {
+		switch err.(type) {
+		case broadway.InstanceNotFoundError, deployment.RevisionNotFoundError:
+			c.JSON(http.StatusNotFound, NotFoundError)
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, InternalError)
+			return
+		}
+	}
+	c.JSON(http.StatusAccepted, map[string]string{
+		"status": "queued",
+	})
+}
+
+// queueRollback checks that an instance has a revision and queues applying it
+// again
+func (s *Server) queueRollback(playbookID, instanceID string, revision int, user string) error {
+	service := services.NewInstanceService(s.store)
+	if _, err := service.Show(playbookID, instanceID); err != nil {
+		return err
+	}
+	if _, err := deployment.GetRevision(s.store, playbookID, instanceID, revision); err != nil {
+		return err
+	}
+	return s.queue.PushRequest(deployment.Request{
+		PlaybookID: playbookID,
+		InstanceID: instanceID,
+		User:       user,
+		Rollback:   revision,
+	})
+}
+
+// requestUser names who made an API request: the X-Broadway-User header if
+// set, else the client address
+func requestUser(c *gin.Context) string {
+	if user := c.Request.Header.Get(userHeader); user != "" {
+		return user
+	}
+	return c.ClientIP()
+}
+
 func (s *Server) getInstance(c *gin.Context) {
 	service := services.NewInstanceService(s.store)
 	i, err := service.Show(c.Param("playbookID"), c.Param("instanceID"))
@@ -114,6 +491,45 @@ func (s *Server) getInstance(c *gin.Context) {
 	c.JSON(http.StatusOK, i)
 }
 
+func (s *Server) deleteInstance(c *gin.Context) {
+	service := services.NewInstanceService(s.store)
+	err := service.Delete(c.Param("playbookID"), c.Param("instanceID"), s.cleanup)
+
+	if err != nil {
+		switch err.(type) {
+		case broadway.InstanceNotFoundError:
+			c.JSON(http.StatusNotFound, NotFoundError)
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, CustomError("Failed to delete instance: "+err.Error()))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, map[string]string{
+		"status": "deleted",
+	})
+}
+
+// teardownInstance removes the namespace an instance was deployed into, on the
+// cluster it was deployed to, and forgets its revision history. The cluster
+// is resolved as the workers resolve it, so an instance without a cluster of
+// its own is torn down on its playbook's cluster.
+func (s *Server) teardownInstance(i broadway.Instance) error {
+	namespace := i.Namespace
+	if namespace == "" {
+		namespace = deployment.NamespaceFor(i.PlaybookID, i.ID)
+	}
+	pb := s.playbooks.Catalog().Playbooks[i.PlaybookID]
+	client, err := s.clusters.Client(s.clusters.Resolve(i.Cluster, pb.Cluster))
+	if err != nil {
+		return err
+	}
+	if err := deployment.Teardown(client, namespace); err != nil {
+		return err
+	}
+	return deployment.DeleteRevisions(s.store, i.PlaybookID, i.ID)
+}
+
 func (s *Server) getInstances(c *gin.Context) {
 	instances, err := instance.List(s.store, c.Param("playbookID"))
 	if err != nil {
@@ -189,10 +605,10 @@ func (s *Server) postCommand(c *gin.Context) {
 		return
 	}
 	if form.Text == "help" {
-		c.String(http.StatusOK, "/broadway status playbook1 instance1: Check the status of instance1\n /broadway deploy playbook1 instance1: Deploy instance1")
+		c.String(http.StatusOK, "/broadway status playbook1 instance1: Check the status of instance1\n /broadway deploy playbook1 instance1: Deploy instance1\n /broadway delete playbook1 instance1: Delete instance1\n /broadway rollback playbook1 instance1 3: Roll instance1 back to revision 3")
 		return
 	}
-	output, err := helperRunCommand(form.Text)
+	output, err := s.helperRunCommand(form.Text, form.UserName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, InternalError)
 		return
@@ -201,6 +617,70 @@ func (s *Server) postCommand(c *gin.Context) {
 	return
 }
 
-func helperRunCommand(text string) (string, error) {
+func (s *Server) helperRunCommand(text, user string) (string, error) {
+	args := strings.Fields(text)
+	if len(args) == 3 && args[0] == "delete" {
+		return s.deleteCommand(args[1], args[2])
+	}
+	if len(args) == 3 && args[0] == "deploy" {
+		return s.deployCommand(args[1], args[2], user)
+	}
+	if len(args) == 4 && args[0] == "rollback" {
+		return s.rollbackCommand(args[1], args[2], args[3], user)
+	}
 	return "unimplemented :sadpanda:", nil
 }
+
+func (s *Server) deployCommand(playbookID, instanceID, user string) (string, error) {
+	service := services.NewInstanceService(s.store)
+	_, err := service.Show(playbookID, instanceID)
+	if err != nil {
+		switch err.(type) {
+		case broadway.InstanceNotFoundError:
+			return fmt.Sprintf("Instance %s/%s not found", playbookID, instanceID), nil
+		default:
+			return "", err
+		}
+	}
+	if err := s.queue.PushRequest(deployment.Request{
+		PlaybookID: playbookID,
+		InstanceID: instanceID,
+		User:       user,
+	}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Deploying %s/%s", playbookID, instanceID), nil
+}
+
+func (s *Server) rollbackCommand(playbookID, instanceID, revision, user string) (string, error) {
+	number, err := strconv.Atoi(revision)
+	if err != nil {
+		return fmt.Sprintf("Revision %s is not a number", revision), nil
+	}
+	err = s.queueRollback(playbookID, instanceID, number, user)
+	if err != nil {
+		switch err.(type) {
+		case broadway.InstanceNotFoundError:
+			return fmt.Sprintf("Instance %s/%s not found", playbookID, instanceID), nil
+		case deployment.RevisionNotFoundError:
+			return fmt.Sprintf("Instance %s/%s has no revision %d", playbookID, instanceID, number), nil
+		default:
+			return "", err
+		}
+	}
+	return fmt.Sprintf("Rolling %s/%s back to revision %d", playbookID, instanceID, number), nil
+}
+
+func (s *Server) deleteCommand(playbookID, instanceID string) (string, error) {
+	service := services.NewInstanceService(s.store)
+	err := service.Delete(playbookID, instanceID, s.cleanup)
+	if err != nil {
+		switch err.(type) {
+		case broadway.InstanceNotFoundError:
+			return fmt.Sprintf("Instance %s/%s not found", playbookID, instanceID), nil
+		default:
+			return fmt.Sprintf("Failed to delete %s/%s: %s", playbookID, instanceID, err), nil
+		}
+	}
+	return fmt.Sprintf("Deleted %s/%s", playbookID, instanceID), nil
+}
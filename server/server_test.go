@@ -3,22 +3,48 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/namely/broadway/broadway"
+	"github.com/namely/broadway/deployment"
 	"github.com/namely/broadway/instance"
+	"github.com/namely/broadway/manifest"
+	"github.com/namely/broadway/playbook"
 	"github.com/namely/broadway/services"
 	"github.com/namely/broadway/store"
 
 	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/client/testing/core"
+	"k8s.io/kubernetes/pkg/client/typed/generated/core/v1/fake"
+	"k8s.io/kubernetes/pkg/runtime"
 )
 
 var testToken = "BroadwayTestToken"
 
+var testClusters = deployment.SingleCluster(&fake.FakeCore{Fake: &core.Fake{}})
+
+var testPlaybooks = []playbook.Playbook{
+	{
+		ID:    "test",
+		Name:  "Test playbook",
+		Meta:  playbook.Meta{Team: "Test team"},
+		Vars:  []playbook.Var{{Name: "version"}},
+		Tasks: []playbook.Task{{Name: "Deploy", Manifests: []string{"web-rc"}}},
+	},
+	{ID: "other", Name: "Other playbook"},
+}
+
 func TestServerNew(t *testing.T) {
 	err := os.Setenv(slackTokenENV, testToken)
 	if err != nil {
@@ -30,7 +56,7 @@ func TestServerNew(t *testing.T) {
 
 	mem := store.New()
 
-	s := New(mem)
+	s := New(mem, testClusters)
 	assert.Equal(t, testToken, s.slackToken, "Expected server.slackToken to match existing ENV value")
 
 	err = os.Unsetenv(slackTokenENV)
@@ -40,7 +66,7 @@ func TestServerNew(t *testing.T) {
 	actualToken, exists = os.LookupEnv(slackTokenENV)
 	assert.False(t, exists, "Expected ENV to not exist")
 	assert.Equal(t, "", actualToken, "Unexpected ENV value")
-	s = New(mem)
+	s = New(mem, testClusters)
 	assert.Equal(t, "", s.slackToken, "Expected server.slackToken to be empty string for missing ENV value")
 
 }
@@ -71,7 +97,9 @@ func TestInstanceCreateWithValidAttributes(t *testing.T) {
 
 	mem := store.New()
 
-	server := New(mem).Handler()
+	s := New(mem, testClusters)
+	s.SetPlaybooks(playbook.NewRegistry(testPlaybooks, manifest.NewRegistry()))
+	server := s.Handler()
 	server.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusCreated, w.Code, "Response code should be 201")
@@ -89,6 +117,14 @@ func TestInstanceCreateWithValidAttributes(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "test", ii.ID, "New instance was created")
 
+	queued := false
+	for _, r := range deployment.NewQueue(mem).Pending() {
+		if r.PlaybookID == "test" && r.InstanceID == "test" {
+			queued = true
+		}
+	}
+	assert.True(t, queued, "New instance was queued for deployment")
+
 }
 
 func TestCreateInstanceWithInvalidAttributes(t *testing.T) {
@@ -120,7 +156,7 @@ func TestCreateInstanceWithInvalidAttributes(t *testing.T) {
 
 		mem := store.New()
 
-		server := New(mem).Handler()
+		server := New(mem, testClusters).Handler()
 		server.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusBadRequest, w.Code, "Expected POST /instances with wrong attributes to be 400")
@@ -137,6 +173,242 @@ func TestCreateInstanceWithInvalidAttributes(t *testing.T) {
 
 }
 
+func TestCreateInstanceWithUnknownPlaybook(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/instances", bytes.NewBufferString(`{"playbook_id": "missing", "id": "test"}`))
+	req.Header.Add("Content-Type", "application/json")
+
+	mem := store.NewMemory()
+	s := New(mem, testClusters)
+	s.SetPlaybooks(playbook.NewRegistry(testPlaybooks, manifest.NewRegistry()))
+	s.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Unknown playbook: missing")
+	_, err := instance.Get(mem, "missing", "test")
+	assert.IsType(t, instance.NotFoundError{}, err)
+}
+
+func TestCreateInstanceWithUnknownCluster(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/instances", bytes.NewBufferString(`{"playbook_id": "test", "id": "test", "cluster": "prod"}`))
+	req.Header.Add("Content-Type", "application/json")
+
+	mem := store.NewMemory()
+	s := New(mem, testClusters)
+	s.SetPlaybooks(playbook.NewRegistry(testPlaybooks, manifest.NewRegistry()))
+	s.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Unknown cluster: prod")
+	_, err := instance.Get(mem, "test", "test")
+	assert.IsType(t, instance.NotFoundError{}, err)
+	assert.Empty(t, s.queue.Pending())
+}
+
+func TestCreateInstanceWithInvalidVars(t *testing.T) {
+	s := New(store.NewMemory(), testClusters)
+	s.SetPlaybooks(playbook.NewRegistry([]playbook.Playbook{{
+		ID: "web",
+		Vars: []playbook.Var{
+			{Name: "version", Required: true},
+			{Name: "tier", Enum: []string{"web", "worker"}},
+		},
+	}}, manifest.NewRegistry()))
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"playbook_id": "web",
+		"id":          "1",
+		"vars":        map[string]string{"tier": "db", "owner": "jane"},
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/instances", bytes.NewBuffer(body))
+	s.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var response VarsErrorResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, VarsErrorResponse{
+		Error: "Invalid vars",
+		Violations: []string{
+			"var version is required",
+			"var tier must be one of web, worker",
+			"var owner is not declared by playbook web",
+		},
+	}, response)
+}
+
+func TestGetPlaybooks(t *testing.T) {
+	s := New(store.NewMemory(), testClusters)
+	s.SetPlaybooks(playbook.NewRegistry(testPlaybooks, manifest.NewRegistry()))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/playbooks", nil)
+	s.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var playbooks []playbook.Playbook
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &playbooks))
+	if assert.Len(t, playbooks, 2) {
+		assert.Equal(t, "other", playbooks[0].ID)
+		assert.Equal(t, "test", playbooks[1].ID)
+	}
+}
+
+func TestGetPlaybook(t *testing.T) {
+	s := New(store.NewMemory(), testClusters)
+	s.SetPlaybooks(playbook.NewRegistry(testPlaybooks, manifest.NewRegistry()))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/playbooks/test", nil)
+	s.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []interface{}{"version"}, response["vars"])
+	assert.Equal(t, map[string]interface{}{"team": "Test team", "email": "", "slack": ""}, response["meta"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "Deploy", "manifests": []interface{}{"web-rc"}}}, response["tasks"])
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/playbooks/missing", nil)
+	s.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetPlaybookErrors(t *testing.T) {
+	s := New(store.NewMemory(), testClusters)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/playbooks/errors", nil)
+	s.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[]", strings.TrimSpace(w.Body.String()))
+
+	dir, err := ioutil.TempDir("", "broadway-playbooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "broken.yml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("id: broken\n"), 0644))
+	defer func(root string) { playbook.ManifestRoot = root }(playbook.ManifestRoot)
+	playbook.ManifestRoot = dir
+	registry, err := playbook.LoadRegistry(dir, nil, nil)
+	assert.NotNil(t, err)
+	s.SetPlaybooks(registry)
+
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var errs []playbook.FileError
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &errs))
+	assert.Equal(t, []playbook.FileError{{Path: path, Error: "Playbook missing required Name"}}, errs)
+}
+
+func TestPutPlaybook(t *testing.T) {
+	mem := store.NewMemory()
+	s := New(mem, testClusters)
+	s.SetPlaybooks(playbook.NewRegistry(testPlaybooks, manifest.NewRegistry()))
+	upload := func(id, name string, manifests map[string]string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"playbook":  "id: web\nname: " + name + "\ntasks:\n  - name: Deploy\n    manifests: [web-rc]\n",
+			"manifests": manifests,
+		})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/playbooks/"+id, bytes.NewBuffer(body))
+		req.Header.Set(userHeader, "jane")
+		s.Handler().ServeHTTP(w, req)
+		return w
+	}
+	manifests := map[string]string{"web-rc": "kind: ReplicationController\n"}
+
+	for n, name := range []string{"Web", "Web v2"} {
+		w := upload("web", name, manifests)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var v playbook.Version
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &v))
+		assert.Equal(t, n+1, v.Number)
+		assert.Equal(t, "jane", v.User)
+		assert.Empty(t, v.Playbook)
+	}
+	catalog := s.playbooks.Catalog()
+	assert.Equal(t, "Web v2", catalog.Playbooks["web"].Name)
+	assert.Equal(t, 2, catalog.Versions["web"])
+	assert.Contains(t, catalog.Playbooks, "test")
+
+	w := upload("web", "Web v3", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Task Deploy uses missing manifest web-rc")
+	w = upload("api", "API", manifests)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Playbook id web does not match api")
+	w = upload("web", "Web v3\ncluster: prod", manifests)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Cluster prod is not configured")
+	latest, err := playbook.LatestVersionNumber(mem, "web")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, latest)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/playbooks/web/versions", nil)
+	s.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var versions []playbook.Version
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &versions))
+	if assert.Len(t, versions, 2) {
+		assert.Equal(t, 1, versions[0].Number)
+		assert.Empty(t, versions[0].Manifests)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/playbooks/web/versions/1", nil)
+	s.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var v playbook.Version
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &v))
+	assert.Contains(t, v.Playbook, "name: Web\n")
+	assert.Equal(t, manifests, v.Manifests)
+
+	for _, path := range []string{"/playbooks/web/versions/3", "/playbooks/api/versions"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", path, nil)
+		s.Handler().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+}
+
+func TestGetPlaybookInstances(t *testing.T) {
+	mem := store.NewMemory()
+	for _, id := range []string{"a", "b"} {
+		if err := instance.New(mem, &instance.Attributes{PlaybookID: "test", ID: id}).Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := New(mem, testClusters)
+	s.SetPlaybooks(playbook.NewRegistry(testPlaybooks, manifest.NewRegistry()))
+
+	testcases := []struct {
+		path      string
+		code      int
+		instances int
+	}{
+		{"/playbooks/test/instances", http.StatusOK, 2},
+		{"/playbooks/other/instances", http.StatusOK, 0},
+		{"/playbooks/missing/instances", http.StatusNotFound, 0},
+	}
+	for _, testcase := range testcases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", testcase.path, nil)
+		s.Handler().ServeHTTP(w, req)
+		assert.Equal(t, testcase.code, w.Code, testcase.path)
+		if testcase.code == http.StatusOK {
+			var instances []instance.Attributes
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &instances))
+			assert.Len(t, instances, testcase.instances, testcase.path)
+		}
+	}
+}
+
 func TestGetInstanceWithValidPath(t *testing.T) {
 	w := httptest.NewRecorder()
 	mem := store.New()
@@ -157,7 +429,7 @@ func TestGetInstanceWithValidPath(t *testing.T) {
 		return
 	}
 
-	server := New(mem).Handler()
+	server := New(mem, testClusters).Handler()
 	server.ServeHTTP(w, req)
 
 	assert.Equal(t, w.Code, http.StatusOK)
@@ -183,7 +455,7 @@ func TestGetInstanceWithInvalidPath(t *testing.T) {
 
 	mem := store.New()
 
-	server := New(mem).Handler()
+	server := New(mem, testClusters).Handler()
 	server.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusNotFound, w.Code)
@@ -226,7 +498,7 @@ func TestGetInstancesWithFullPlaybook(t *testing.T) {
 		return
 	}
 
-	server := New(mem).Handler()
+	server := New(mem, testClusters).Handler()
 	server.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code, "Response code should be 200 OK")
@@ -255,7 +527,7 @@ func TestGetInstancesWithEmptyPlaybook(t *testing.T) {
 
 	mem := store.New()
 
-	server := New(mem).Handler()
+	server := New(mem, testClusters).Handler()
 	server.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusNoContent, w.Code, "Response code should be 204 No Content")
@@ -308,7 +580,7 @@ func TestGetStatusFailures(t *testing.T) {
 	}
 
 	mem := store.New()
-	server := New(mem).Handler()
+	server := New(mem, testClusters).Handler()
 
 	for _, i := range invalidRequests {
 		w := httptest.NewRecorder()
@@ -344,7 +616,7 @@ func TestGetStatusWithGoodPath(t *testing.T) {
 	req, err := http.NewRequest("GET", "/status/goodPlaybook/goodInstance", nil)
 	assert.Nil(t, err)
 
-	server := New(mem).Handler()
+	server := New(mem, testClusters).Handler()
 	server.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -359,7 +631,7 @@ func TestGetStatusWithGoodPath(t *testing.T) {
 func helperSetupServer() (*httptest.ResponseRecorder, http.Handler) {
 	w := httptest.NewRecorder()
 	mem := store.New()
-	server := New(mem).Handler()
+	server := New(mem, testClusters).Handler()
 	return w, server
 }
 
@@ -425,3 +697,440 @@ func TestPostCommandHelp(t *testing.T) {
 }
 func TestPostCommand(t *testing.T) {
 }
+
+func TestDeleteInstance(t *testing.T) {
+	mem := store.New()
+	i := instance.New(mem, &instance.Attributes{
+		PlaybookID:  "foo",
+		ID:          "deleteMe",
+		DeployState: broadway.DeployState{Namespace: "broadway-foo-deleteme"},
+	})
+	if err := i.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	var cleaned string
+	s := New(mem, testClusters)
+	s.cleanup = func(i broadway.Instance) error {
+		cleaned = i.Namespace
+		return nil
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/instance/foo/deleteMe", nil)
+	s.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "broadway-foo-deleteme", cleaned)
+
+	_, err := services.NewInstanceService(mem).Show("foo", "deleteMe")
+	assert.IsType(t, broadway.InstanceNotFoundError{}, err)
+}
+
+func TestDeleteInstanceWhenCleanupFails(t *testing.T) {
+	mem := store.New()
+	i := instance.New(mem, &instance.Attributes{
+		PlaybookID: "foo",
+		ID:         "stuck",
+	})
+	if err := i.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(mem, testClusters)
+	s.cleanup = func(i broadway.Instance) error {
+		return errors.New("cluster unreachable")
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/instance/foo/stuck", nil)
+	s.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "cluster unreachable")
+
+	ii, err := services.NewInstanceService(mem).Show("foo", "stuck")
+	assert.Nil(t, err)
+	assert.Equal(t, broadway.Status(broadway.StatusError), ii.Status)
+}
+
+func TestDeleteInstanceWithInvalidPath(t *testing.T) {
+	w, server := helperSetupServer()
+	req, _ := http.NewRequest("DELETE", "/instance/foo/missing", nil)
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPostCommandDelete(t *testing.T) {
+	if err := os.Setenv(slackTokenENV, testToken); err != nil {
+		t.Fatal(err)
+	}
+	mem := store.New()
+	i := instance.New(mem, &instance.Attributes{
+		PlaybookID: "foo",
+		ID:         "slackDelete",
+	})
+	if err := i.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s := New(mem, testClusters)
+	s.cleanup = func(i broadway.Instance) error { return nil }
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/command", nil)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	form := url.Values{}
+	form.Set("token", testToken)
+	form.Set("command", "/broadway")
+	form.Set("text", "delete foo slackDelete")
+	req.PostForm = form
+
+	s.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Deleted foo/slackDelete")
+}
+
+func TestDeployInstanceIsQueued(t *testing.T) {
+	mem := store.New()
+	i := instance.New(mem, &instance.Attributes{
+		PlaybookID: "foo",
+		ID:         "queued",
+	})
+	if err := i.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s := New(mem, testClusters)
+	s.queue = deployment.NewQueue(store.NewMemory())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/instance/foo/queued/deploy", nil)
+	req.Header.Set("X-Broadway-User", "alice")
+	s.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	pending := s.queue.Pending()
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, "foo", pending[0].PlaybookID)
+		assert.Equal(t, "queued", pending[0].InstanceID)
+		assert.Equal(t, "alice", pending[0].User)
+	}
+}
+
+func TestDeployInstanceWithInvalidPath(t *testing.T) {
+	w, server := helperSetupServer()
+	req, _ := http.NewRequest("POST", "/instance/foo/missing/deploy", nil)
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPostCommandDeploy(t *testing.T) {
+	if err := os.Setenv(slackTokenENV, testToken); err != nil {
+		t.Fatal(err)
+	}
+	mem := store.New()
+	i := instance.New(mem, &instance.Attributes{
+		PlaybookID: "foo",
+		ID:         "slackDeploy",
+	})
+	if err := i.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s := New(mem, testClusters)
+	s.queue = deployment.NewQueue(store.NewMemory())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/command", nil)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	form := url.Values{}
+	form.Set("token", testToken)
+	form.Set("command", "/broadway")
+	form.Set("text", "deploy foo slackDeploy")
+	form.Set("user_name", "carol")
+	req.PostForm = form
+
+	s.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Deploying foo/slackDeploy")
+	pending := s.queue.Pending()
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, "carol", pending[0].User)
+	}
+}
+
+func TestGetInstancesShowsCluster(t *testing.T) {
+	mem := store.New()
+	i := instance.New(mem, &instance.Attributes{
+		PlaybookID: "testPlaybookClusters",
+		ID:         "onQA",
+		Cluster:    "qa",
+	})
+	if err := i.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/instances/testPlaybookClusters", nil)
+	New(mem, testClusters).Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var okResponse []instance.Attributes
+	err := json.Unmarshal(w.Body.Bytes(), &okResponse)
+	assert.Nil(t, err)
+	if assert.Len(t, okResponse, 1) {
+		assert.Equal(t, "qa", okResponse[0].Cluster)
+	}
+}
+
+func TestTeardownInstanceOnUnknownCluster(t *testing.T) {
+	s := New(store.New(), testClusters)
+	err := s.teardownInstance(broadway.Instance{PlaybookID: "foo", ID: "bar", Cluster: "prod"})
+	assert.Equal(t, deployment.UnknownClusterError{Name: "prod"}, err)
+}
+
+func TestTeardownInstanceOnPlaybookCluster(t *testing.T) {
+	s := New(store.New(), testClusters)
+	s.SetPlaybooks(playbook.NewRegistry([]playbook.Playbook{{ID: "foo", Cluster: "prod"}}, manifest.NewRegistry()))
+	err := s.teardownInstance(broadway.Instance{PlaybookID: "foo", ID: "bar"})
+	assert.Equal(t, deployment.UnknownClusterError{Name: "prod"}, err)
+}
+
+func TestTeardownInstanceDeletesRevisions(t *testing.T) {
+	mem := store.New()
+	err := deployment.SaveRevision(mem, "foo", "bar", &deployment.Revision{Number: 1})
+	assert.Nil(t, err)
+
+	s := New(mem, testClusters)
+	assert.Nil(t, s.teardownInstance(broadway.Instance{PlaybookID: "foo", ID: "bar"}))
+	revisions, err := deployment.Revisions(mem, "foo", "bar")
+	assert.Nil(t, err)
+	assert.Empty(t, revisions)
+}
+
+func helperSetupRevisions(t *testing.T, instanceID string) (store.Store, *Server) {
+	mem := store.NewMemory()
+	i := instance.New(mem, &instance.Attributes{PlaybookID: "foo", ID: instanceID})
+	if err := i.Save(); err != nil {
+		t.Fatal(err)
+	}
+	for _, rev := range []*deployment.Revision{
+		{Number: 2, User: "bob", Outcome: deployment.OutcomeFailed},
+		{Number: 1, User: "alice", Outcome: deployment.OutcomeSucceeded},
+	} {
+		if err := deployment.SaveRevision(mem, "foo", instanceID, rev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := New(mem, testClusters)
+	s.queue = deployment.NewQueue(store.NewMemory())
+	return mem, s
+}
+
+func TestGetRevisions(t *testing.T) {
+	_, s := helperSetupRevisions(t, "history")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/instance/foo/history/revisions", nil)
+	s.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var revisions []deployment.Revision
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &revisions))
+	if assert.Len(t, revisions, 2) {
+		assert.Equal(t, 1, revisions[0].Number)
+		assert.Equal(t, "alice", revisions[0].User)
+		assert.Equal(t, 2, revisions[1].Number)
+	}
+}
+
+func TestGetRevisionsWithInvalidPath(t *testing.T) {
+	w, server := helperSetupServer()
+	req, _ := http.NewRequest("GET", "/instance/foo/missing/revisions", nil)
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRollbackInstanceIsQueued(t *testing.T) {
+	_, s := helperSetupRevisions(t, "rollback")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/instance/foo/rollback/rollback/1", nil)
+	req.Header.Set("X-Broadway-User", "alice")
+	s.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	pending := s.queue.Pending()
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, "rollback", pending[0].InstanceID)
+		assert.Equal(t, 1, pending[0].Rollback)
+		assert.Equal(t, "alice", pending[0].User)
+	}
+}
+
+func TestRollbackInstanceFailures(t *testing.T) {
+	_, s := helperSetupRevisions(t, "rollback")
+
+	testcases := []struct {
+		path string
+		code int
+	}{
+		{"/instance/foo/rollback/rollback/9", http.StatusNotFound},
+		{"/instance/foo/missing/rollback/1", http.StatusNotFound},
+		{"/instance/foo/rollback/rollback/latest", http.StatusBadRequest},
+	}
+	for _, testcase := range testcases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", testcase.path, nil)
+		s.Handler().ServeHTTP(w, req)
+		assert.Equal(t, testcase.code, w.Code, testcase.path)
+	}
+	assert.Empty(t, s.queue.Pending())
+}
+
+var planManifest = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - port: {{.port}}
+`
+
+func TestPlanInstance(t *testing.T) {
+	mem := store.NewMemory()
+	i := instance.New(mem, &instance.Attributes{PlaybookID: "foo", ID: "plan", Vars: map[string]string{"port": "80"}})
+	if err := i.Save(); err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.New("web", planManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests := manifest.NewRegistry()
+	manifests.Add(m)
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	f.AddReactor("get", "*", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(unversioned.GroupResource{}, "web")
+	})
+	s := New(mem, deployment.SingleCluster(f))
+	s.SetPlaybooks(playbook.NewRegistry([]playbook.Playbook{
+		{
+			ID:    "foo",
+			Vars:  []playbook.Var{{Name: "port"}},
+			Tasks: []playbook.Task{{Name: "Service", Manifests: []string{"web"}}},
+		},
+	}, manifests))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/instance/foo/plan/plan", bytes.NewBufferString(`{"vars": {"port": "8080"}}`))
+	s.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var plan deployment.Plan
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &plan))
+	assert.Equal(t, []deployment.TaskPlan{{
+		Name:    "Service",
+		Objects: []deployment.ObjectPlan{{Kind: "Service", Name: "web", Action: deployment.ActionCreate}},
+	}}, plan.Tasks)
+	for _, action := range f.Actions() {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+	assert.Empty(t, s.queue.Pending())
+}
+
+func TestPlanInstanceWithInvalidVars(t *testing.T) {
+	mem := store.NewMemory()
+	i := instance.New(mem, &instance.Attributes{PlaybookID: "web", ID: "plan", Vars: map[string]string{"version": "1"}})
+	if err := i.Save(); err != nil {
+		t.Fatal(err)
+	}
+	f := &fake.FakeCore{Fake: &core.Fake{}}
+	s := New(mem, deployment.SingleCluster(f))
+	s.SetPlaybooks(playbook.NewRegistry([]playbook.Playbook{{
+		ID: "web",
+		Vars: []playbook.Var{
+			{Name: "version", Required: true},
+			{Name: "tier", Enum: []string{"web", "worker"}},
+		},
+	}}, manifest.NewRegistry()))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/instance/web/plan/plan", bytes.NewBufferString(`{"vars": {"version": "", "tier": "db"}}`))
+	s.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var response VarsErrorResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, VarsErrorResponse{
+		Error: "Invalid vars",
+		Violations: []string{
+			"var version is required",
+			"var tier must be one of web, worker",
+		},
+	}, response)
+	assert.Empty(t, f.Actions())
+}
+
+func TestPlanInstanceFailures(t *testing.T) {
+	mem := store.NewMemory()
+	i := instance.New(mem, &instance.Attributes{PlaybookID: "unknown", ID: "plan"})
+	if err := i.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s := New(mem, testClusters)
+
+	testcases := []struct {
+		path string
+		body string
+		code int
+	}{
+		{"/instance/foo/missing/plan", "", http.StatusNotFound},
+		{"/instance/unknown/plan/plan", "", http.StatusNotFound},
+		{"/instance/unknown/plan/plan", "{", http.StatusBadRequest},
+	}
+	for _, testcase := range testcases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", testcase.path, bytes.NewBufferString(testcase.body))
+		s.Handler().ServeHTTP(w, req)
+		assert.Equal(t, testcase.code, w.Code, testcase.path)
+	}
+}
+
+func TestPostCommandRollback(t *testing.T) {
+	if err := os.Setenv(slackTokenENV, testToken); err != nil {
+		t.Fatal(err)
+	}
+	_, s := helperSetupRevisions(t, "slackRollback")
+
+	testcases := []struct {
+		text     string
+		expected string
+	}{
+		{"rollback foo slackRollback 1", "Rolling foo/slackRollback back to revision 1"},
+		{"rollback foo slackRollback 9", "Instance foo/slackRollback has no revision 9"},
+		{"rollback foo missing 1", "Instance foo/missing not found"},
+		{"rollback foo slackRollback latest", "Revision latest is not a number"},
+	}
+	for _, testcase := range testcases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/command", nil)
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		form := url.Values{}
+		form.Set("token", testToken)
+		form.Set("command", "/broadway")
+		form.Set("text", testcase.text)
+		form.Set("user_name", "carol")
+		req.PostForm = form
+
+		s.Handler().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, testcase.expected, w.Body.String())
+	}
+	pending := s.queue.Pending()
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, 1, pending[0].Rollback)
+		assert.Equal(t, "carol", pending[0].User)
+	}
+}
@@ -1,6 +1,8 @@
 package services
 
 import (
+	"time"
+
 	"github.com/namely/broadway/broadway"
 	"github.com/namely/broadway/store"
 )
@@ -16,11 +18,59 @@ func NewInstanceService(s store.Store) *InstanceService {
 	return &InstanceService{repo: r}
 }
 
-// Create a new instance
+// Create a new instance, or update an existing one. Created is set to the
+// time the instance was first created. An update changes the vars, and the
+// cluster if i names one, but keeps what deployments recorded on the
+// instance, such as its status, revision and namespace.
 func (is *InstanceService) Create(i broadway.Instance) error {
+	if existing, err := is.repo.FindByID(i.PlaybookID, i.ID); err == nil {
+		i.Created = existing.Created
+		keepDeployState(&i, existing)
+	}
+	if i.Created == "" {
+		i.Created = time.Now().UTC().Format(time.RFC3339)
+	}
 	return is.repo.Save(i)
 }
 
+// keepDeployState copies the fields deployments record from existing to i
+func keepDeployState(i *broadway.Instance, existing broadway.Instance) {
+	i.Status = existing.Status
+	i.DeployState = existing.DeployState
+	if i.Cluster == "" {
+		i.Cluster = existing.Cluster
+	}
+}
+
+// Cleanup removes the Kubernetes resources deployed for an instance
+type Cleanup func(i broadway.Instance) error
+
+// Delete moves the instance to StatusDeleting and runs cleanup. The stored
+// instance is removed only once cleanup succeeds; otherwise it is saved with
+// StatusError and the cleanup error as its reason.
+func (is *InstanceService) Delete(playbookID, ID string, cleanup Cleanup) error {
+	instance, err := is.repo.FindByID(playbookID, ID)
+	if err != nil {
+		return err
+	}
+
+	instance.Status = broadway.StatusDeleting
+	instance.Reason = ""
+	if err := is.repo.Save(instance); err != nil {
+		return err
+	}
+
+	if err := cleanup(instance); err != nil {
+		instance.Status = broadway.StatusError
+		instance.Reason = "Failed to delete: " + err.Error()
+		if saveErr := is.repo.Save(instance); saveErr != nil {
+			return saveErr
+		}
+		return err
+	}
+	return is.repo.Delete(instance)
+}
+
 // Show takes playbookID and instanceID and returns the matching Instance, if
 // any
 func (is *InstanceService) Show(playbookID, ID string) (broadway.Instance, error) {
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/namely/broadway/broadway"
@@ -20,6 +21,59 @@ func TestCreateInstance(t *testing.T) {
 	assert.Equal(t, broadway.StatusNew, createdInstance.Status)
 }
 
+func TestCreateInstanceKeepsCreated(t *testing.T) {
+	store := store.New()
+	service := NewInstanceService(store)
+
+	i := broadway.Instance{PlaybookID: "test", ID: "created"}
+	assert.Nil(t, service.Create(i))
+	first, _ := service.Show(i.PlaybookID, i.ID)
+	assert.NotEmpty(t, first.Created)
+
+	i.Created = "2000-01-01T00:00:00Z"
+	i.Vars = map[string]string{"version": "2"}
+	assert.Nil(t, service.Create(i))
+	updated, _ := service.Show(i.PlaybookID, i.ID)
+	assert.Equal(t, first.Created, updated.Created)
+	assert.Equal(t, "2", updated.Vars["version"])
+}
+
+func TestCreateInstanceKeepsDeployState(t *testing.T) {
+	store := store.New()
+	service := NewInstanceService(store)
+
+	state := broadway.DeployState{
+		Namespace:        "broadway-test-redeployed",
+		Reason:           "Rollout timed out",
+		FailedTask:       "Deploy",
+		Deployed:         "2016-05-10T14:02:11Z",
+		Revision:         3,
+		PlaybookVersion:  2,
+		FailedRevision:   3,
+		RestoredRevision: 2,
+		FailingPods:      []broadway.PodStatus{{Name: "web-1", Phase: "Pending"}},
+	}
+	deployed := broadway.Instance{
+		PlaybookID:  "test",
+		ID:          "redeployed",
+		Status:      broadway.StatusDeployed,
+		Cluster:     "qa",
+		DeployState: state,
+	}
+	assert.Nil(t, service.Create(deployed))
+	assert.Nil(t, service.Create(broadway.Instance{
+		PlaybookID: "test",
+		ID:         "redeployed",
+		Vars:       map[string]string{"version": "2"},
+	}))
+
+	updated, _ := service.Show("test", "redeployed")
+	assert.Equal(t, "2", updated.Vars["version"])
+	assert.Equal(t, broadway.Status(broadway.StatusDeployed), updated.Status)
+	assert.Equal(t, "qa", updated.Cluster)
+	assert.Equal(t, state, updated.DeployState)
+}
+
 func TestShow(t *testing.T) {
 	store := store.New()
 	service := NewInstanceService(store)
@@ -41,3 +95,53 @@ func TestShowMissingInstance(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Empty(t, instance.PlaybookID, "PlaybookID should be empty")
 }
+
+func TestDelete(t *testing.T) {
+	store := store.New()
+	service := NewInstanceService(store)
+
+	i := broadway.Instance{PlaybookID: "test", ID: "deleteme"}
+	err := service.Create(i)
+	assert.Nil(t, err)
+
+	var cleaned broadway.Instance
+	err = service.Delete(i.PlaybookID, i.ID, func(i broadway.Instance) error {
+		cleaned = i
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, broadway.Status(broadway.StatusDeleting), cleaned.Status)
+
+	_, err = service.Show(i.PlaybookID, i.ID)
+	assert.IsType(t, broadway.InstanceNotFoundError{}, err)
+}
+
+func TestDeleteWhenCleanupFails(t *testing.T) {
+	store := store.New()
+	service := NewInstanceService(store)
+
+	i := broadway.Instance{PlaybookID: "test", ID: "stuck"}
+	err := service.Create(i)
+	assert.Nil(t, err)
+
+	err = service.Delete(i.PlaybookID, i.ID, func(i broadway.Instance) error {
+		return errors.New("cluster unreachable")
+	})
+	assert.NotNil(t, err)
+
+	instance, err := service.Show(i.PlaybookID, i.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, broadway.Status(broadway.StatusError), instance.Status)
+	assert.Contains(t, instance.Reason, "cluster unreachable")
+}
+
+func TestDeleteMissingInstance(t *testing.T) {
+	store := store.New()
+	service := NewInstanceService(store)
+
+	err := service.Delete("test", "missing", func(i broadway.Instance) error {
+		t.Error("Cleanup should not run for a missing instance")
+		return nil
+	})
+	assert.IsType(t, broadway.InstanceNotFoundError{}, err)
+}
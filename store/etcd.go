@@ -37,6 +37,42 @@ func (*etcdStore) SetValue(path, value string) error {
 	return err
 }
 
+// CreateValue sets the string value for a string key that does not exist
+// yet, or returns ErrExists.
+func (*etcdStore) CreateValue(path, value string) error {
+	_, err := api.Set(context.Background(), path, value, &etcdclient.SetOptions{PrevExist: etcdclient.PrevNoExist})
+	if e, ok := err.(etcdclient.Error); ok && e.Code == etcdclient.ErrorCodeNodeExist {
+		return ErrExists
+	}
+	return err
+}
+
+// SwapValue sets the string value for a string key that still holds old,
+// or returns ErrChanged.
+func (*etcdStore) SwapValue(path, old, value string) error {
+	_, err := api.Set(context.Background(), path, value, &etcdclient.SetOptions{PrevValue: old, PrevExist: etcdclient.PrevExist})
+	return changedError(err)
+}
+
+// DeleteValue removes a string key that still holds old, or returns
+// ErrChanged.
+func (*etcdStore) DeleteValue(path, old string) error {
+	_, err := api.Delete(context.Background(), path, &etcdclient.DeleteOptions{PrevValue: old})
+	return changedError(err)
+}
+
+// changedError maps the errors etcd returns for a failed comparison to
+// ErrChanged
+func changedError(err error) error {
+	if e, ok := err.(etcdclient.Error); ok {
+		switch e.Code {
+		case etcdclient.ErrorCodeTestFailed, etcdclient.ErrorCodeKeyNotFound:
+			return ErrChanged
+		}
+	}
+	return err
+}
+
 // Value retrieves the string value for a string key.
 func (*etcdStore) Value(path string) string {
 	resp, err := api.Get(context.Background(), path, nil)
@@ -49,21 +85,23 @@ func (*etcdStore) Value(path string) string {
 // Values finds all leaf nodes under the given key. It strips any leading path
 // components from the keys and returns a key/value map. For example, given keys
 // "animals/flea" and "animals/cats/egyptian", Values("animals") would return
-// {"flea" : "...", "egyptian": "..."}
+// {"flea" : "...", "egyptian": "..."}. A missing or empty key has no values,
+// which is not an error: queues are polled while they are empty.
 func (*etcdStore) Values(path string) (values map[string]string) {
 	values = map[string]string{}
 	resp, err := api.Get(context.Background(), path, &etcdclient.GetOptions{Recursive: true})
+	if e, ok := err.(etcdclient.Error); ok && e.Code == etcdclient.ErrorCodeKeyNotFound {
+		return values
+	}
 	if err != nil {
 		log.Println("Ignoring error getting values:" + path)
 		log.Println(err)
 		return values
 	}
-	if resp.Node != nil && len(resp.Node.Nodes) > 0 {
+	if resp.Node != nil {
 		for _, node := range resp.Node.Nodes {
 			values[lastKeyItem(node.Key)] = node.Value
 		}
-	} else {
-		log.Println("No values found here: " + path)
 	}
 	return values
 }
@@ -53,3 +53,27 @@ func TestDelete(t *testing.T) {
 
 	assert.Equal(t, "", s.Value("/testd"))
 }
+
+func TestCreateValue(t *testing.T) {
+	for _, s := range []Store{New(), NewMemory()} {
+		assert.Nil(t, s.CreateValue("/testc", "A"))
+		assert.Equal(t, ErrExists, s.CreateValue("/testc", "B"))
+		assert.Equal(t, "A", s.Value("/testc"))
+		assert.Nil(t, s.Delete("/testc"))
+	}
+}
+
+func TestSwapAndDeleteValue(t *testing.T) {
+	for _, s := range []Store{New(), NewMemory()} {
+		assert.Equal(t, ErrChanged, s.SwapValue("/tests", "A", "B"))
+		assert.Nil(t, s.SetValue("/tests", "A"))
+		assert.Nil(t, s.SwapValue("/tests", "A", "B"))
+		assert.Equal(t, ErrChanged, s.SwapValue("/tests", "A", "C"))
+		assert.Equal(t, "B", s.Value("/tests"))
+
+		assert.Equal(t, ErrChanged, s.DeleteValue("/tests", "A"))
+		assert.Nil(t, s.DeleteValue("/tests", "B"))
+		assert.Equal(t, "", s.Value("/tests"))
+		assert.Equal(t, ErrChanged, s.DeleteValue("/tests", "B"))
+	}
+}
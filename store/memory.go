@@ -1,6 +1,9 @@
 package store
 
-import "sync"
+import (
+	"strings"
+	"sync"
+)
 
 type memoryStore struct {
 	sync.Mutex
@@ -21,6 +24,42 @@ func (s *memoryStore) SetValue(path, value string) error {
 	return nil
 }
 
+// CreateValue sets the string value for a string key that does not exist
+// yet, or returns ErrExists.
+func (s *memoryStore) CreateValue(path, value string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.store[path]; ok {
+		return ErrExists
+	}
+	s.store[path] = value
+	return nil
+}
+
+// SwapValue sets the string value for a string key that still holds old,
+// or returns ErrChanged.
+func (s *memoryStore) SwapValue(path, old, value string) error {
+	s.Lock()
+	defer s.Unlock()
+	if current, ok := s.store[path]; !ok || current != old {
+		return ErrChanged
+	}
+	s.store[path] = value
+	return nil
+}
+
+// DeleteValue removes a string key that still holds old, or returns
+// ErrChanged.
+func (s *memoryStore) DeleteValue(path, old string) error {
+	s.Lock()
+	defer s.Unlock()
+	if current, ok := s.store[path]; !ok || current != old {
+		return ErrChanged
+	}
+	delete(s.store, path)
+	return nil
+}
+
 // Value retrieves the string value for a string key.
 func (s *memoryStore) Value(path string) string {
 	s.Lock()
@@ -33,10 +72,28 @@ func (s *memoryStore) Value(path string) string {
 // "animals/flea" and "animals/cats/egyptian", Values("animals") would return
 // {"flea" : "...", "egyptian": "..."}
 func (s *memoryStore) Values(path string) map[string]string {
-	return s.store
+	s.Lock()
+	defer s.Unlock()
+	values := map[string]string{}
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for key, value := range s.store {
+		if strings.HasPrefix(key, prefix) {
+			values[lastKeyItem(key)] = value
+		}
+	}
+	return values
 }
 
-// Delete removes the specified key and its value from the store
+// Delete removes the specified key and its value from the store, along with
+// any keys nested under it
 func (s *memoryStore) Delete(path string) error {
+	s.Lock()
+	defer s.Unlock()
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for key := range s.store {
+		if key == path || strings.HasPrefix(key, prefix) {
+			delete(s.store, key)
+		}
+	}
 	return nil
 }
@@ -1,8 +1,26 @@
 package store
 
+import "errors"
+
+// ErrExists is returned by CreateValue when the key already has a value
+var ErrExists = errors.New("Key already exists")
+
+// ErrChanged is returned by SwapValue and DeleteValue when the key does not
+// hold the value expected
+var ErrChanged = errors.New("Key does not hold the expected value")
+
 // Store declares an interface for a key/value store
 type Store interface {
 	SetValue(path, value string) error
+	// CreateValue sets the value of a key only if it has none yet, and
+	// returns ErrExists otherwise, atomically
+	CreateValue(path, value string) error
+	// SwapValue sets the value of a key only if it still holds old, and
+	// returns ErrChanged otherwise, atomically
+	SwapValue(path, old, value string) error
+	// DeleteValue deletes a key only if it still holds old, and returns
+	// ErrChanged otherwise, atomically
+	DeleteValue(path, old string) error
 	Value(path string) string
 	Values(path string) map[string]string
 	Delete(path string) error